@@ -0,0 +1,53 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+const adminPathPrefix = "/admin/"
+
+// admin serves the admin API of the operators:
+//
+//	GET /admin/plugins  the health of the plugins
+func (bot *robot) admin(w http.ResponseWriter, r *http.Request) {
+	switch path := strings.TrimPrefix(r.URL.Path, adminPathPrefix); {
+	case path == "plugins" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, bot.pluginStatuses())
+	default:
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+	}
+}
+
+// pluginStatuses reports the plugins in the order of the configmap, the ones
+// without the health checks are always healthy.
+func (bot *robot) pluginStatuses() []pluginStatus {
+	statuses := []pluginStatus{}
+	if bot.configmap == nil {
+		return statuses
+	}
+
+	for i := range bot.configmap.ConfigItems.Plugins {
+		name := bot.configmap.ConfigItems.Plugins[i].Name
+		if h, ok := bot.health[name]; ok {
+			statuses = append(statuses, h.status())
+		} else {
+			statuses = append(statuses, pluginStatus{Name: name, Healthy: true})
+		}
+	}
+
+	return statuses
+}
@@ -0,0 +1,180 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: delivery/v1/delivery.proto
+
+package deliveryv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Delivery_Deliver_FullMethodName       = "/robot.delivery.v1.Delivery/Deliver"
+	Delivery_DeliverStream_FullMethodName = "/robot.delivery.v1.Delivery/DeliverStream"
+)
+
+// DeliveryClient is the client API for Delivery service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Delivery is implemented by the plugins which receive the events over gRPC,
+// the plugins should implement the standard health checking protocol as well.
+type DeliveryClient interface {
+	// Deliver delivers an event to the plugin.
+	Deliver(ctx context.Context, in *Event, opts ...grpc.CallOption) (*Ack, error)
+	// DeliverStream delivers the events over a long-lived stream,
+	// the plugin acknowledges each of them by its id.
+	DeliverStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[Event, Ack], error)
+}
+
+type deliveryClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDeliveryClient(cc grpc.ClientConnInterface) DeliveryClient {
+	return &deliveryClient{cc}
+}
+
+func (c *deliveryClient) Deliver(ctx context.Context, in *Event, opts ...grpc.CallOption) (*Ack, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Ack)
+	err := c.cc.Invoke(ctx, Delivery_Deliver_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *deliveryClient) DeliverStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[Event, Ack], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Delivery_ServiceDesc.Streams[0], Delivery_DeliverStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Event, Ack]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Delivery_DeliverStreamClient = grpc.BidiStreamingClient[Event, Ack]
+
+// DeliveryServer is the server API for Delivery service.
+// All implementations must embed UnimplementedDeliveryServer
+// for forward compatibility.
+//
+// Delivery is implemented by the plugins which receive the events over gRPC,
+// the plugins should implement the standard health checking protocol as well.
+type DeliveryServer interface {
+	// Deliver delivers an event to the plugin.
+	Deliver(context.Context, *Event) (*Ack, error)
+	// DeliverStream delivers the events over a long-lived stream,
+	// the plugin acknowledges each of them by its id.
+	DeliverStream(grpc.BidiStreamingServer[Event, Ack]) error
+	mustEmbedUnimplementedDeliveryServer()
+}
+
+// UnimplementedDeliveryServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedDeliveryServer struct{}
+
+func (UnimplementedDeliveryServer) Deliver(context.Context, *Event) (*Ack, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Deliver not implemented")
+}
+func (UnimplementedDeliveryServer) DeliverStream(grpc.BidiStreamingServer[Event, Ack]) error {
+	return status.Errorf(codes.Unimplemented, "method DeliverStream not implemented")
+}
+func (UnimplementedDeliveryServer) mustEmbedUnimplementedDeliveryServer() {}
+func (UnimplementedDeliveryServer) testEmbeddedByValue()                  {}
+
+// UnsafeDeliveryServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DeliveryServer will
+// result in compilation errors.
+type UnsafeDeliveryServer interface {
+	mustEmbedUnimplementedDeliveryServer()
+}
+
+func RegisterDeliveryServer(s grpc.ServiceRegistrar, srv DeliveryServer) {
+	// If the following call pancis, it indicates UnimplementedDeliveryServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Delivery_ServiceDesc, srv)
+}
+
+func _Delivery_Deliver_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Event)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DeliveryServer).Deliver(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Delivery_Deliver_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DeliveryServer).Deliver(ctx, req.(*Event))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Delivery_DeliverStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(DeliveryServer).DeliverStream(&grpc.GenericServerStream[Event, Ack]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Delivery_DeliverStreamServer = grpc.BidiStreamingServer[Event, Ack]
+
+// Delivery_ServiceDesc is the grpc.ServiceDesc for Delivery service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Delivery_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "robot.delivery.v1.Delivery",
+	HandlerType: (*DeliveryServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Deliver",
+			Handler:    _Delivery_Deliver_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "DeliverStream",
+			Handler:       _Delivery_DeliverStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "delivery/v1/delivery.proto",
+}
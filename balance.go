@@ -0,0 +1,394 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"errors"
+	"hash/crc32"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	balanceRoundRobin     = "round_robin"
+	balanceLeastInFlight  = "least_in_flight"
+	balanceConsistentHash = "consistent_hash"
+	balanceFailover       = "failover"
+
+	defaultOutlierConsecutiveFailures = 5
+	defaultOutlierEjectionTime        = 30 * time.Second
+
+	// hashReplicas is the number of the points of each endpoint on the hash ring
+	hashReplicas = 64
+)
+
+var errNoEndpoints = errors.New("no endpoint of the plugin is available")
+
+var balanceStrategies = []string{balanceRoundRobin, balanceLeastInFlight, balanceConsistentHash, balanceFailover}
+
+// outlierConfig ejects an endpoint for a while after the consecutive delivery failures.
+// The failures are the errors of the connections and the 5xx responses.
+type outlierConfig struct {
+	// ConsecutiveFailures is the number of the failures ejecting the endpoint. Defaults to 5.
+	ConsecutiveFailures int `json:"consecutive_failures,omitempty"`
+
+	// EjectionTime is how long the endpoint is ejected. Defaults to "30s".
+	EjectionTime duration `json:"ejection_time,omitempty"`
+}
+
+func (c *outlierConfig) validate() error {
+	if c.ConsecutiveFailures < 0 || c.EjectionTime < 0 {
+		return errors.New("the consecutive failures and the ejection time can not be negative")
+	}
+
+	return nil
+}
+
+// endpoints returns all the endpoints of the plugin.
+func (p *pluginConfig) endpoints() []string {
+	if len(p.Endpoints) > 0 {
+		return p.Endpoints
+	}
+	if p.Endpoint == "" {
+		return nil
+	}
+
+	return []string{p.Endpoint}
+}
+
+// endpointProblems checks the fields locating the plugin in the push mode.
+func (p *pluginConfig) endpointProblems() (problems []pluginProblem) {
+	add := func(field, msg string) {
+		problems = append(problems, pluginProblem{field: field, err: errors.New(p.Name + " plugin " + msg)})
+	}
+	checkURL := func(field, endpoint string) {
+		if err := validateEndpoint(endpoint); err != nil {
+			problems = append(problems, pluginProblem{field: field, err: err, url: true})
+		}
+	}
+
+	if p.Endpoint != "" && len(p.Endpoints) > 0 {
+		add("endpoints", "can not set both endpoint and endpoints")
+	}
+
+	endpoints := p.endpoints()
+	if p.Discovery != nil {
+		if len(endpoints) > 0 {
+			add("discovery", "can not set both endpoints and discovery")
+		}
+		if err := p.Discovery.validate(); err != nil {
+			add("discovery", "has an invalid discovery: "+err.Error())
+		}
+		if p.Batch != nil {
+			add("batch", "can not batch the events to the discovered endpoints")
+		}
+	} else if len(endpoints) == 0 {
+		add("endpoint", "missing endpoint")
+	}
+	if p.Endpoint != "" {
+		checkURL("endpoint", p.Endpoint)
+	}
+	for i, e := range p.Endpoints {
+		checkURL("endpoints["+strconv.Itoa(i)+"]", e)
+	}
+
+	if p.Balance != "" && !slices.Contains(balanceStrategies, p.Balance) {
+		add("balance", "has an unknown balance "+p.Balance)
+	}
+
+	if p.OutlierDetection != nil {
+		if err := p.OutlierDetection.validate(); err != nil {
+			add("outlier_detection", "has an invalid outlier detection: "+err.Error())
+		}
+	}
+
+	if p.Batch != nil && len(endpoints) > 1 {
+		add("batch", "can not batch the events to multiple endpoints")
+	}
+
+	return
+}
+
+// balancedEndpoint is an endpoint of the balanced sink.
+type balancedEndpoint struct {
+	sink
+	uri string // the redacted endpoint
+
+	inflight     int
+	failures     int // the consecutive failures
+	ejectedUntil time.Time
+}
+
+func (e *balancedEndpoint) ejected(now time.Time) bool {
+	return now.Before(e.ejectedUntil)
+}
+
+// balancedSink spreads the messages over the endpoints of a plugin. A message failed
+// by an endpoint is sent to the next one picked by the strategy, and the outliers
+// are ejected for a while.
+type balancedSink struct {
+	name         string
+	strategy     string
+	maxFailures  int
+	ejectionTime time.Duration
+
+	lock      sync.Mutex
+	endpoints []*balancedEndpoint
+	next      int      // the next endpoint of the round robin
+	ring      []uint32 // the sorted points of the hash ring
+	owners    map[uint32]int
+}
+
+func newBalancedSink(p *pluginConfig, sinks []sink, uris []string) *balancedSink {
+	b := &balancedSink{
+		name:         p.Name,
+		strategy:     p.Balance,
+		maxFailures:  defaultOutlierConsecutiveFailures,
+		ejectionTime: defaultOutlierEjectionTime,
+	}
+	if b.strategy == "" {
+		b.strategy = balanceRoundRobin
+	}
+	if c := p.OutlierDetection; c != nil {
+		if c.ConsecutiveFailures > 0 {
+			b.maxFailures = c.ConsecutiveFailures
+		}
+		b.ejectionTime = c.EjectionTime.or(b.ejectionTime)
+	}
+
+	endpoints := make([]*balancedEndpoint, len(sinks))
+	for i := range sinks {
+		endpoints[i] = &balancedEndpoint{sink: sinks[i], uri: uris[i]}
+	}
+	b.setEndpoints(endpoints)
+
+	return b
+}
+
+// update replaces the endpoints by the resolved ones, the sinks and the states of the
+// kept endpoints are reused. It returns the sinks which are not used any more, they
+// are the ones just created if it fails.
+func (b *balancedSink) update(resolved []string, create func(endpoint string) (sink, error)) (unused []sink, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	current := make(map[string]*balancedEndpoint, len(b.endpoints))
+	for _, e := range b.endpoints {
+		current[e.uri] = e
+	}
+
+	var created []sink
+	endpoints := make([]*balancedEndpoint, 0, len(resolved))
+	seen := map[string]bool{}
+	for _, endpoint := range resolved {
+		uri := redactedURL(endpoint)
+		if seen[uri] {
+			continue
+		}
+		seen[uri] = true
+
+		if e, ok := current[uri]; ok {
+			endpoints = append(endpoints, e)
+			delete(current, uri)
+			continue
+		}
+
+		s, err := create(endpoint)
+		if err != nil {
+			return created, err
+		}
+		created = append(created, s)
+		endpoints = append(endpoints, &balancedEndpoint{sink: s, uri: uri})
+	}
+	for _, e := range current {
+		unused = append(unused, e.sink)
+	}
+	b.setEndpoints(endpoints)
+
+	return
+}
+
+// setEndpoints sets the endpoints and builds the hash ring of them, the lock must be held.
+func (b *balancedSink) setEndpoints(endpoints []*balancedEndpoint) {
+	b.endpoints = endpoints
+	if b.next >= len(endpoints) {
+		b.next = 0
+	}
+
+	if b.strategy != balanceConsistentHash {
+		return
+	}
+
+	b.ring, b.owners = nil, map[uint32]int{}
+	for i, e := range endpoints {
+		for j := 0; j < hashReplicas; j++ {
+			point := crc32.ChecksumIEEE([]byte(e.uri + "#" + strconv.Itoa(j)))
+			if _, ok := b.owners[point]; !ok {
+				b.owners[point] = i
+				b.ring = append(b.ring, point)
+			}
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool { return b.ring[i] < b.ring[j] })
+}
+
+func (b *balancedSink) send(msg *message) error {
+	_, err := b.sendStatus(msg)
+	return err
+}
+
+// sendStatus sends the message to the endpoints in the order of the strategy until
+// one of them does not fail.
+func (b *balancedSink) sendStatus(msg *message) (code int, err error) {
+	endpoints := b.pick(msg)
+	if len(endpoints) == 0 {
+		return 0, errNoEndpoints
+	}
+
+	for _, e := range endpoints {
+		b.lock.Lock()
+		e.inflight++
+		b.lock.Unlock()
+
+		code, err = sendStatus(e.sink, msg)
+
+		failed := err != nil && (code == 0 || code >= 500)
+		b.observe(e, failed, time.Now())
+		if !failed {
+			return
+		}
+	}
+
+	return
+}
+
+// observe counts the result of a delivery to the endpoint, and ejects it if it is an outlier.
+func (b *balancedSink) observe(e *balancedEndpoint, failed bool, now time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	e.inflight--
+	if !failed {
+		e.failures = 0
+		return
+	}
+
+	e.failures++
+	if e.failures >= b.maxFailures && !e.ejected(now) {
+		e.failures = 0
+		e.ejectedUntil = now.Add(b.ejectionTime)
+		endpointEjections.WithLabelValues(b.name, e.uri).Inc()
+	}
+}
+
+// pick returns the endpoints in the order to be tried. The ejected ones are tried
+// last, so the messages are still sent if all of them are ejected.
+func (b *balancedSink) pick(msg *message) []*balancedEndpoint {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	n := len(b.endpoints)
+	order := make([]int, 0, n)
+	if n == 0 {
+		return nil
+	}
+
+	switch b.strategy {
+	case balanceRoundRobin:
+		for i := 0; i < n; i++ {
+			order = append(order, (b.next+i)%n)
+		}
+		b.next = (b.next + 1) % n
+	case balanceLeastInFlight:
+		for i := 0; i < n; i++ {
+			order = append(order, i)
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return b.endpoints[order[i]].inflight < b.endpoints[order[j]].inflight
+		})
+	case balanceConsistentHash:
+		order = b.walkRing(msg.org + "/" + msg.repo)
+	default:
+		for i := 0; i < n; i++ {
+			order = append(order, i)
+		}
+	}
+
+	now := time.Now()
+	picked := make([]*balancedEndpoint, 0, n)
+	for _, i := range order {
+		if !b.endpoints[i].ejected(now) {
+			picked = append(picked, b.endpoints[i])
+		}
+	}
+	for _, i := range order {
+		if b.endpoints[i].ejected(now) {
+			picked = append(picked, b.endpoints[i])
+		}
+	}
+
+	return picked
+}
+
+// walkRing returns the distinct endpoints met clockwise from the point of the key.
+func (b *balancedSink) walkRing(key string) []int {
+	point := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(b.ring), func(i int) bool { return b.ring[i] >= point })
+
+	order := make([]int, 0, len(b.endpoints))
+	seen := make([]bool, len(b.endpoints))
+	for i := 0; i < len(b.ring) && len(order) < len(b.endpoints); i++ {
+		owner := b.owners[b.ring[(start+i)%len(b.ring)]]
+		if !seen[owner] {
+			seen[owner] = true
+			order = append(order, owner)
+		}
+	}
+
+	return order
+}
+
+func (b *balancedSink) close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	var errs []error
+	for _, e := range b.endpoints {
+		if err := e.close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// newEndpointSinks creates the sinks of all the endpoints of the plugin.
+func newEndpointSinks(bot *robot, p *pluginConfig) (sinks []sink, uris []string, err error) {
+	for _, endpoint := range p.endpoints() {
+		var s sink
+		if s, err = newEndpointSink(bot, p, endpoint); err != nil {
+			for _, s := range sinks {
+				_ = s.close()
+			}
+			return nil, nil, err
+		}
+		sinks = append(sinks, s)
+		uris = append(uris, redactedURL(endpoint))
+	}
+
+	return
+}
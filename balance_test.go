@@ -0,0 +1,171 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// replicaSink counts the messages, and fails them by err.
+type replicaSink struct {
+	lock sync.Mutex
+	n    int
+	err  error
+}
+
+func (s *replicaSink) send(*message) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.n++
+	return s.err
+}
+
+func (s *replicaSink) close() error {
+	return nil
+}
+
+func newTestBalancedSink(p *pluginConfig, n int) (*balancedSink, []*replicaSink) {
+	replicas := make([]*replicaSink, n)
+	sinks := make([]sink, n)
+	uris := make([]string, n)
+	for i := range replicas {
+		replicas[i] = &replicaSink{}
+		sinks[i], uris[i] = replicas[i], "http://replica"+strconv.Itoa(i)
+	}
+
+	return newBalancedSink(p, sinks, uris), replicas
+}
+
+func counts(replicas []*replicaSink) []int {
+	n := make([]int, len(replicas))
+	for i, r := range replicas {
+		n[i] = r.n
+	}
+	return n
+}
+
+func TestBalancedSinkRoundRobin(t *testing.T) {
+	b, replicas := newTestBalancedSink(&pluginConfig{Name: "balance1"}, 3)
+	for i := 0; i < 6; i++ {
+		assert.Equal(t, nil, b.send(&message{}))
+	}
+	assert.Equal(t, []int{2, 2, 2}, counts(replicas))
+
+	// the failed messages go to the next endpoint
+	replicas[0].err = errors.New("down")
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, nil, b.send(&message{}))
+	}
+	assert.Equal(t, []int{3, 4, 3}, counts(replicas))
+
+	// the client errors are not retried
+	replicas[0].err = &statusError{code: http.StatusBadRequest}
+	b.next = 0
+	assert.Equal(t, replicas[0].err, b.send(&message{}))
+	assert.Equal(t, []int{4, 4, 3}, counts(replicas))
+}
+
+func TestBalancedSinkFailover(t *testing.T) {
+	b, replicas := newTestBalancedSink(&pluginConfig{
+		Name:             "balance2",
+		Balance:          balanceFailover,
+		OutlierDetection: &outlierConfig{ConsecutiveFailures: 2, EjectionTime: duration(time.Hour)},
+	}, 2)
+
+	assert.Equal(t, nil, b.send(&message{}))
+	assert.Equal(t, []int{1, 0}, counts(replicas))
+
+	// the primary is ejected after 2 failures, and the backup is used
+	replicas[0].err = errors.New("down")
+	assert.Equal(t, nil, b.send(&message{}))
+	assert.Equal(t, nil, b.send(&message{}))
+	assert.Equal(t, 1.0, testutil.ToFloat64(endpointEjections.WithLabelValues("balance2", "http://replica0")))
+	assert.Equal(t, nil, b.send(&message{}))
+	assert.Equal(t, []int{3, 3}, counts(replicas))
+
+	// the ejected endpoints are still tried if all of them fail
+	replicas[1].err = errors.New("down")
+	assert.Equal(t, replicas[0].err, b.send(&message{}))
+	assert.Equal(t, []int{4, 4}, counts(replicas))
+}
+
+func TestBalancedSinkConsistentHash(t *testing.T) {
+	b, replicas := newTestBalancedSink(&pluginConfig{Name: "balance3", Balance: balanceConsistentHash}, 3)
+
+	for i := 0; i < 20; i++ {
+		owner := b.pick(&message{org: "ibforuorg", repo: "repo" + strconv.Itoa(i)})[0]
+		for j := 0; j < 3; j++ {
+			assert.Equal(t, nil, b.send(&message{org: "ibforuorg", repo: "repo" + strconv.Itoa(i)}))
+		}
+		assert.Equal(t, owner, b.pick(&message{org: "ibforuorg", repo: "repo" + strconv.Itoa(i)})[0])
+	}
+
+	// every repo sticks to one replica, and the repos are spread over them
+	used := 0
+	for _, n := range counts(replicas) {
+		assert.Equal(t, 0, n%3)
+		if n > 0 {
+			used++
+		}
+	}
+	assert.Greater(t, used, 1)
+}
+
+func TestBalancedSinkLeastInFlight(t *testing.T) {
+	b, _ := newTestBalancedSink(&pluginConfig{Name: "balance4", Balance: balanceLeastInFlight}, 3)
+	b.endpoints[0].inflight = 2
+	b.endpoints[1].inflight = 1
+
+	picked := b.pick(&message{})
+	assert.Equal(t, []*balancedEndpoint{b.endpoints[2], b.endpoints[1], b.endpoints[0]}, picked)
+}
+
+func TestDispatchBalanced(t *testing.T) {
+	received := make(chan string, 10)
+	handler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received <- name
+		})
+	}
+	server1 := httptest.NewServer(handler("replica1"))
+	defer server1.Close()
+	server2 := httptest.NewServer(handler("replica2"))
+	defer server2.Close()
+
+	bot := newRobot(&configuration{ConfigItems: accessConfig{Plugins: []pluginConfig{{
+		Name: "serv1", Endpoints: []string{server1.URL, server2.URL},
+	}}}})
+	p := &bot.configmap.ConfigItems.Plugins[0]
+	assert.Equal(t, server1.URL+","+server2.URL, p.location())
+
+	s, err := bot.sinkOf(p)
+	assert.Equal(t, nil, err)
+	for i := 0; i < 2; i++ {
+		code, err := sendStatus(s, &message{header: http.Header{}, body: []byte("{}")})
+		assert.Equal(t, nil, err)
+		assert.Equal(t, http.StatusOK, code)
+	}
+	assert.Equal(t, "replica1", <-received)
+	assert.Equal(t, "replica2", <-received)
+	bot.wait()
+}
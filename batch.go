@@ -0,0 +1,334 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"golang.org/x/time/rate"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	batchJSON   = "json"
+	batchNDJSON = "ndjson"
+
+	contentTypeNDJSON = "application/x-ndjson"
+
+	// headerRobotBatchSize tells how many events are in the batch
+	headerRobotBatchSize = "Robot-Batch-Size"
+
+	defaultBatchMaxSize = 100
+	defaultBatchMaxWait = time.Second
+)
+
+// batchConfig accumulates the events of a plugin, and posts them in one request.
+// The body is a JSON array or NDJSON of the items:
+//
+//	{"id": "{delivery id}", "headers": {...}, "payload": {...}}
+//
+// The payload is in "payloadBase64" instead if it is not JSON. The plugin responds
+// 2xx if the batch is accepted, and lists the items to be retried, if any, by
+//
+//	{"failed": [{"id": "{delivery id}", "reason": "..."}]}
+//
+// The whole batch is retried if the plugin responds otherwise.
+type batchConfig struct {
+	// MaxSize is the maximum number of the events in a batch. Defaults to 100.
+	MaxSize int `json:"max_size,omitempty"`
+
+	// MaxWait is how long the first event of a batch waits for the others. Defaults to "1s".
+	MaxWait duration `json:"max_wait,omitempty"`
+
+	// Encoding of the body, "json" or "ndjson". Defaults to "json".
+	Encoding string `json:"encoding,omitempty"`
+}
+
+func (c *batchConfig) validate() error {
+	if c.MaxSize < 0 {
+		return errors.New("the max size can not be negative")
+	}
+
+	if c.Encoding != "" && c.Encoding != batchJSON && c.Encoding != batchNDJSON {
+		return errors.New("unknown encoding " + c.Encoding)
+	}
+
+	return nil
+}
+
+type batchItem struct {
+	ID            string            `json:"id"`
+	Headers       map[string]string `json:"headers"`
+	Payload       json.RawMessage   `json:"payload,omitempty"`
+	PayloadBase64 []byte            `json:"payloadBase64,omitempty"`
+}
+
+type batchResponse struct {
+	Failed []struct {
+		ID     string `json:"id"`
+		Reason string `json:"reason"`
+	} `json:"failed"`
+}
+
+type batchEntry struct {
+	msg  *message
+	done func(error)
+	err  error
+}
+
+// batchSink accumulates the messages, and posts them to the plugin when the batch is
+// full or its first message has waited long enough. The batches are posted one by one
+// by a worker, and at most QueueSize messages wait for it.
+type batchSink struct {
+	http     *httpSink
+	name     string
+	size     int // the maximum number of the pending messages
+	maxSize  int
+	maxWait  time.Duration
+	encoding string
+	limiter  *rate.Limiter // it limits the rate of the batches, if any
+	health   *pluginHealth // the batches are held while it is unhealthy, if any
+
+	lock    sync.Mutex
+	closed  bool
+	entries []*batchEntry
+	pending int // the messages accumulated or waiting in the batches
+	timer   *time.Timer
+	batches chan []*batchEntry
+	stopped chan struct{}
+}
+
+func newBatchSink(s *httpSink, p *pluginConfig, health *pluginHealth) *batchSink {
+	b := &batchSink{
+		http:     s,
+		name:     p.Name,
+		size:     p.QueueSize,
+		health:   health,
+		maxSize:  p.Batch.MaxSize,
+		maxWait:  p.Batch.MaxWait.or(defaultBatchMaxWait),
+		encoding: p.Batch.Encoding,
+		stopped:  make(chan struct{}),
+	}
+	if b.size <= 0 {
+		b.size = defaultQueueSize
+	}
+	if b.maxSize <= 0 {
+		b.maxSize = defaultBatchMaxSize
+	}
+	if p.RateLimit != nil {
+		b.limiter = rate.NewLimiter(rate.Limit(p.RateLimit.Rate), p.RateLimit.burst())
+	}
+	// a batch has one message at least, so the flushes never block
+	b.batches = make(chan []*batchEntry, b.size)
+	go b.run()
+
+	return b
+}
+
+func (b *batchSink) enqueue(msg *message, done func(error)) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.closed {
+		return errQueueClosed
+	}
+	if b.pending >= b.size {
+		return errQueueFull
+	}
+
+	b.pending++
+	queueDepth.WithLabelValues(b.name).Inc()
+	b.entries = append(b.entries, &batchEntry{msg: msg, done: done})
+	switch {
+	case len(b.entries) >= b.maxSize:
+		b.flushLocked()
+	case b.timer == nil:
+		b.timer = time.AfterFunc(b.maxWait, b.flush)
+	}
+
+	return nil
+}
+
+// send posts the message in a batch, and waits for the result.
+func (b *batchSink) send(msg *message) error {
+	result := make(chan error, 1)
+	if err := b.enqueue(msg, func(err error) { result <- err }); err != nil {
+		return err
+	}
+
+	return <-result
+}
+
+func (b *batchSink) saturated() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.pending >= b.size
+}
+
+func (b *batchSink) flush() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.flushLocked()
+}
+
+// flushLocked passes the accumulated messages to the worker, the lock must be held.
+func (b *batchSink) flushLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.entries) == 0 {
+		return
+	}
+
+	b.batches <- b.entries
+	b.entries = nil
+}
+
+func (b *batchSink) run() {
+	defer close(b.stopped)
+
+	for entries := range b.batches {
+		b.post(entries)
+
+		b.lock.Lock()
+		b.pending -= len(entries)
+		b.lock.Unlock()
+		queueDepth.WithLabelValues(b.name).Sub(float64(len(entries)))
+	}
+}
+
+// post sends the batch, and retries the failed items.
+func (b *batchSink) post(entries []*batchEntry) {
+	for i := 0; ; i++ {
+		failed, err := b.postOnce(entries)
+
+		var retries []*batchEntry
+		for _, e := range entries {
+			if err != nil {
+				e.err = err
+			} else if reason, ok := failed[e.msg.id]; ok {
+				e.err = errors.New("the plugin failed the event: " + reason)
+			} else {
+				e.done(nil)
+				continue
+			}
+			retries = append(retries, e)
+		}
+
+		if len(retries) == 0 {
+			return
+		}
+		if i >= retryCount {
+			for _, e := range retries {
+				e.done(e.err)
+			}
+			return
+		}
+
+		entries = retries
+		time.Sleep(retryWaitTime)
+	}
+}
+
+// postOnce posts the batch, and returns the reasons of the failed items by their ids.
+func (b *batchSink) postOnce(entries []*batchEntry) (map[string]string, error) {
+	msg, err := b.encode(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	b.health.wait()
+	if b.limiter != nil {
+		_ = b.limiter.Wait(context.Background())
+	}
+
+	resp, err := b.http.deliver(msg)
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError() {
+		return nil, &statusError{code: resp.StatusCode()}
+	}
+
+	failed := map[string]string{}
+	if body := bytes.TrimSpace(resp.Body()); len(body) > 0 {
+		var v batchResponse
+		if err := json.Unmarshal(body, &v); err != nil {
+			return nil, errors.New("the plugin responded with an invalid body: " + err.Error())
+		}
+		for _, item := range v.Failed {
+			failed[item.ID] = item.Reason
+		}
+	}
+
+	return failed, nil
+}
+
+func (b *batchSink) encode(entries []*batchEntry) (*message, error) {
+	items := make([]batchItem, len(entries))
+	for i, e := range entries {
+		items[i] = batchItem{ID: e.msg.id, Headers: flattenHeader(e.msg.header)}
+		if json.Valid(e.msg.body) {
+			items[i].Payload = e.msg.body
+		} else {
+			items[i].PayloadBase64 = e.msg.body
+		}
+	}
+
+	msg := &message{header: http.Header{}}
+	msg.header.Set(headerRobotBatchSize, strconv.Itoa(len(items)))
+
+	if b.encoding != batchNDJSON {
+		msg.header.Set(headerContentType, contentTypeJSON)
+		body, err := json.Marshal(items)
+		msg.body = body
+
+		return msg, err
+	}
+
+	msg.header.Set(headerContentType, contentTypeNDJSON)
+	buf := bytes.Buffer{}
+	encoder := json.NewEncoder(&buf)
+	for i := range items {
+		if err := encoder.Encode(&items[i]); err != nil {
+			return nil, err
+		}
+	}
+	msg.body = buf.Bytes()
+
+	return msg, nil
+}
+
+// close posts the messages left, and waits for all the batches.
+func (b *batchSink) close() error {
+	b.lock.Lock()
+	if !b.closed {
+		b.closed = true
+		b.flushLocked()
+		close(b.batches)
+	}
+	b.lock.Unlock()
+
+	<-b.stopped
+
+	return b.http.close()
+}
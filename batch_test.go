@@ -0,0 +1,177 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newBatchServer records the ids of the batches, and fails the items by respond.
+func newBatchServer(t *testing.T, respond func(n int, ids []string) (int, string)) (*httptest.Server, func() [][]string) {
+	lock := sync.Mutex{}
+	var batches [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var items []batchItem
+		if r.Header.Get(headerContentType) == contentTypeNDJSON {
+			scanner := bufio.NewScanner(bytes.NewReader(body))
+			for scanner.Scan() {
+				item := batchItem{}
+				assert.Equal(t, nil, json.Unmarshal(scanner.Bytes(), &item))
+				items = append(items, item)
+			}
+		} else {
+			assert.Equal(t, nil, json.Unmarshal(body, &items))
+		}
+
+		ids := make([]string, len(items))
+		for i := range items {
+			ids[i] = items[i].ID
+		}
+		assert.Equal(t, r.Header.Get(headerRobotBatchSize), strconv.Itoa(len(ids)))
+
+		lock.Lock()
+		batches = append(batches, ids)
+		n := len(batches)
+		lock.Unlock()
+
+		code, resp := respond(n, ids)
+		w.WriteHeader(code)
+		_, _ = w.Write([]byte(resp))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() [][]string {
+		lock.Lock()
+		defer lock.Unlock()
+		return batches
+	}
+}
+
+func TestBatchSink(t *testing.T) {
+	testCases := []struct {
+		no      string
+		batch   batchConfig
+		respond func(n int, ids []string) (int, string)
+		out     []any
+	}{
+		{
+			"case0",
+			batchConfig{MaxSize: 3},
+			func(n int, ids []string) (int, string) { return http.StatusOK, "" },
+			[]any{[][]string{{"1", "2", "3"}}, []error{nil, nil, nil}},
+		},
+		{
+			"case1",
+			batchConfig{MaxSize: 3, Encoding: batchNDJSON},
+			func(n int, ids []string) (int, string) {
+				if n == 1 {
+					return http.StatusOK, `{"failed": [{"id": "2", "reason": "busy"}]}`
+				}
+				return http.StatusOK, `{"failed": []}`
+			},
+			[]any{[][]string{{"1", "2", "3"}, {"2"}}, []error{nil, nil, nil}},
+		},
+		{
+			"case2",
+			batchConfig{MaxSize: 3},
+			func(n int, ids []string) (int, string) {
+				return http.StatusOK, `{"failed": [{"id": "3", "reason": "invalid"}]}`
+			},
+			[]any{
+				[][]string{{"1", "2", "3"}, {"3"}, {"3"}},
+				[]error{nil, nil, errors.New("the plugin failed the event: invalid")},
+			},
+		},
+		{
+			"case3",
+			batchConfig{MaxSize: 5, MaxWait: duration(50 * time.Millisecond)},
+			func(n int, ids []string) (int, string) {
+				if n == 1 {
+					return http.StatusBadGateway, ""
+				}
+				return http.StatusOK, ""
+			},
+			[]any{[][]string{{"1", "2", "3"}, {"1", "2", "3"}}, []error{nil, nil, nil}},
+		},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			server, batches := newBatchServer(t, testCases[i].respond)
+
+			bot := newRobot(&configuration{})
+			s, err := bot.sinkOf(&pluginConfig{Name: "serv1", Endpoint: server.URL, Batch: &testCases[i].batch})
+			assert.Equal(t, nil, err)
+
+			q, ok := s.(asyncSink)
+			assert.Equal(t, true, ok)
+
+			results := make([]error, 3)
+			wg := sync.WaitGroup{}
+			for j, id := range []string{"1", "2", "3"} {
+				wg.Add(1)
+				j := j
+				err := q.enqueue(&message{id: id, header: http.Header{}, body: []byte(`{"n":` + id + `}`)}, func(err error) {
+					results[j] = err
+					wg.Done()
+				})
+				assert.Equal(t, nil, err)
+			}
+			wg.Wait()
+
+			assert.Equal(t, testCases[i].out[0], batches())
+			assert.Equal(t, testCases[i].out[1], results)
+			assert.Equal(t, nil, s.close())
+		})
+	}
+}
+
+func TestBatchSinkBounded(t *testing.T) {
+	// the batches wait for the plugin which is stuck
+	release := make(chan struct{})
+	server, batches := newBatchServer(t, func(n int, ids []string) (int, string) {
+		<-release
+		return http.StatusOK, ""
+	})
+
+	bot := newRobot(&configuration{})
+	s, err := bot.sinkOf(&pluginConfig{Name: "batch1", Endpoint: server.URL, QueueSize: 2, Batch: &batchConfig{MaxSize: 1}})
+	assert.Equal(t, nil, err)
+	b := s.(*batchSink)
+
+	done := make(chan error, 2)
+	assert.Equal(t, nil, b.enqueue(&message{id: "1", header: http.Header{}}, func(err error) { done <- err }))
+	assert.Equal(t, nil, b.enqueue(&message{id: "2", header: http.Header{}}, func(err error) { done <- err }))
+	assert.Equal(t, errQueueFull, b.enqueue(&message{id: "3", header: http.Header{}}, func(error) {}))
+	assert.Equal(t, true, b.saturated())
+
+	close(release)
+	assert.Equal(t, nil, <-done)
+	assert.Equal(t, nil, <-done)
+	assert.Equal(t, nil, b.close())
+	assert.Equal(t, false, b.saturated())
+	assert.Equal(t, [][]string{{"1"}, {"2"}}, batches())
+	assert.Equal(t, errQueueClosed, b.enqueue(&message{id: "4", header: http.Header{}}, func(error) {}))
+}
@@ -0,0 +1,197 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memoryPublisher is an in-process stand-in of a message broker, which fails
+// the first publishing as many times as required.
+type memoryPublisher struct {
+	failures  int
+	keys      []string
+	headers   []map[string]string
+	bodies    [][]byte
+	connected int
+	closed    int
+}
+
+func (p *memoryPublisher) publish(_ context.Context, key string, headers map[string]string, body []byte) error {
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("broker is unavailable")
+	}
+	p.keys = append(p.keys, key)
+	p.headers = append(p.headers, headers)
+	p.bodies = append(p.bodies, body)
+	return nil
+}
+
+func (p *memoryPublisher) close() error {
+	p.closed++
+	return nil
+}
+
+func TestBrokerSink(t *testing.T) {
+	testCases := []struct {
+		no  string
+		in  int
+		out []any
+	}{
+		{"case0", 0, []any{nil, 1, 0}},
+		{"case1", retryCount, []any{nil, retryCount + 1, retryCount}},
+		{"case2", retryCount + 1, []any{errors.New("broker is unavailable"), retryCount + 1, retryCount + 1}},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			pub := &memoryPublisher{failures: testCases[i].in}
+			u, _ := url.Parse("kafka://localhost:9092/robot-events")
+			s, _ := newBrokerSink(func(*url.URL) (publisher, error) {
+				pub.connected++
+				return pub, nil
+			})(nil, nil, u)
+
+			msg := &message{header: http.Header{}, body: []byte("{}"), org: "ibforuorg", repo: "test1"}
+			msg.header.Set(headerRobotEventType, eventComment)
+			assert.Equal(t, testCases[i].out[0], s.send(msg))
+			assert.Equal(t, testCases[i].out[1], pub.connected)
+			assert.Equal(t, testCases[i].out[2], pub.closed)
+			if testCases[i].out[0] == nil {
+				assert.Equal(t, []string{"ibforuorg/test1"}, pub.keys)
+				assert.Equal(t, map[string]string{
+					headerRobotEventType: eventComment,
+					headerRobotOrg:       "ibforuorg",
+					headerRobotRepo:      "test1",
+				}, pub.headers[0])
+			}
+		})
+	}
+}
+
+// natsStandIn is an in-process stand-in of a NATS server, which understands
+// just enough of the protocol to receive the published messages.
+type natsStandIn struct {
+	ln   net.Listener
+	msgs chan *nats.Msg
+}
+
+func newNATSStandIn(t *testing.T) *natsStandIn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &natsStandIn{ln: ln, msgs: make(chan *nats.Msg, 16)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *natsStandIn) handle(conn net.Conn) {
+	defer conn.Close()
+
+	_, _ = conn.Write([]byte(`INFO {"server_id":"stand-in","version":"2.10.0","proto":1,"headers":true,"max_payload":1048576}` + "\r\n"))
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "PING":
+			_, _ = conn.Write([]byte("PONG\r\n"))
+		case "HPUB":
+			// HPUB <subject> [reply-to] <header size> <total size>
+			hdr, _ := strconv.Atoi(fields[len(fields)-2])
+			total, _ := strconv.Atoi(fields[len(fields)-1])
+			buf := make([]byte, total+2)
+			if _, err = io.ReadFull(r, buf); err != nil {
+				return
+			}
+
+			tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(buf[:hdr])))
+			_, _ = tp.ReadLine()
+			h, _ := tp.ReadMIMEHeader()
+			s.msgs <- &nats.Msg{Subject: fields[1], Header: nats.Header(h), Data: buf[hdr:total]}
+		}
+	}
+}
+
+func TestDispatchToNATS(t *testing.T) {
+	standIn := newNATSStandIn(t)
+	defer standIn.ln.Close()
+
+	bot := newRobot(&configuration{ConfigItems: accessConfig{
+		RepoPlugins: map[string][]string{"ibforuorg": {"serv1", "serv2"}},
+		Plugins: []pluginConfig{
+			{Name: "serv1", Endpoint: "nats://" + standIn.ln.Addr().String() + "/robot.events", Events: []string{eventComment}},
+			{Name: "serv2", Endpoint: "nats://" + standIn.ln.Addr().String() + "/robot.pushes", Events: []string{eventPush}},
+		},
+	}})
+
+	data, _ := os.ReadFile(findTestdata(t, "pr_note.json"))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/nats", bytes.NewReader(data))
+	req.Header.Set(headerUserAgent, headerUserAgentValue)
+	req.Header.Set(headerContentTypeName, headerContentTypeJsonValue)
+	req.Header.Set(headerEventType, headerEventTypeValue)
+	req.Header.Set(headerEventGUID, headerEventGUIDValue)
+	bot.ServeHTTP(w, req)
+	bot.wait()
+
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	select {
+	case msg := <-standIn.msgs:
+		assert.Equal(t, "robot.events", msg.Subject)
+		assert.Equal(t, "ibforuorg/test1", msg.Header.Get(headerRobotKey))
+		assert.Equal(t, "ibforuorg", msg.Header.Get(headerRobotOrg))
+		assert.Equal(t, "test1", msg.Header.Get(headerRobotRepo))
+		assert.Equal(t, eventComment, msg.Header.Get(headerRobotEventType))
+		assert.Equal(t, headerEventGUIDValue, http.Header(msg.Header).Get(headerEventGUID))
+		assert.Contains(t, string(msg.Data), `"eventGUID":"`+headerEventGUIDValue+`"`)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message is published")
+	}
+	assert.Equal(t, 0, len(standIn.msgs))
+}
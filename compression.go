@@ -0,0 +1,122 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	headerContentEncoding = "Content-Encoding"
+	headerAcceptEncoding  = "Accept-Encoding"
+
+	encodingGzip     = "gzip"
+	encodingZstd     = "zstd"
+	encodingIdentity = "identity"
+
+	// compressionAuto sends the bodies uncompressed until the plugin advertises
+	// the encodings it accepts by the header Accept-Encoding of its responses.
+	compressionAuto = "auto"
+)
+
+var (
+	errUnsupportedEncoding = errors.New("unsupported content encoding")
+
+	// the zstd encoder is safe for concurrent use by EncodeAll
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) { return zstd.NewWriter(nil) })
+)
+
+// compress encodes the body by the content encoding.
+func compress(encoding string, body []byte) ([]byte, error) {
+	switch encoding {
+	case encodingGzip:
+		buf := bytes.Buffer{}
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(body); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case encodingZstd:
+		enc, err := zstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(body, nil), nil
+	case "", encodingIdentity:
+		return body, nil
+	}
+
+	return nil, errUnsupportedEncoding
+}
+
+// decompressReader decodes the body by the header Content-Encoding of a request.
+func decompressReader(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case encodingGzip, "x-gzip":
+		return gzip.NewReader(r)
+	case encodingZstd:
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case "", encodingIdentity:
+		return io.NopCloser(r), nil
+	}
+
+	return nil, errUnsupportedEncoding
+}
+
+func decompress(encoding string, body []byte) ([]byte, error) {
+	r, err := decompressReader(encoding, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// negotiateEncoding picks the encoding from the header Accept-Encoding of a plugin,
+// zstd is preferred to gzip. It returns identity if neither is accepted.
+func negotiateEncoding(accept string) string {
+	accepted := map[string]bool{}
+	for _, item := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(item, ";")
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = true
+	}
+
+	for _, encoding := range []string{encodingZstd, encodingGzip} {
+		if accepted[encoding] {
+			return encoding
+		}
+	}
+
+	return encodingIdentity
+}
@@ -0,0 +1,169 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/opensourceways/robot-framework-lib/client"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	body := bytes.Repeat([]byte(`{"number":"1"}`), 100)
+	for _, encoding := range []string{encodingGzip, encodingZstd, encodingIdentity} {
+		t.Run(encoding, func(t *testing.T) {
+			compressed, err := compress(encoding, body)
+			assert.Equal(t, nil, err)
+			if encoding != encodingIdentity {
+				assert.Less(t, len(compressed), len(body))
+			}
+
+			out, err := decompress(encoding, compressed)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, body, out)
+		})
+	}
+
+	_, err := compress("br", body)
+	assert.Equal(t, errUnsupportedEncoding, err)
+	_, err = decompress("br", body)
+	assert.Equal(t, errUnsupportedEncoding, err)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	testCases := []struct {
+		no  string
+		in  string
+		out string
+	}{
+		{"case0", "", encodingIdentity},
+		{"case1", "gzip", encodingGzip},
+		{"case2", "gzip, zstd", encodingZstd},
+		{"case3", "zstd;q=0, gzip;q=0.5", encodingGzip},
+		{"case4", "br, deflate", encodingIdentity},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			assert.Equal(t, testCases[i].out, negotiateEncoding(testCases[i].in))
+		})
+	}
+}
+
+type recordedRequest struct {
+	encoding string
+	body     []byte
+}
+
+// newCompressionServer responds with the encodings accepted, and rejects the others by 415.
+func newCompressionServer(t *testing.T, accept string) (*httptest.Server, chan recordedRequest) {
+	requests := make(chan recordedRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := r.Header.Get(headerContentEncoding)
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set(headerAcceptEncoding, accept)
+		if encoding != "" && negotiateEncoding(accept) != encoding {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		body, err := decompress(encoding, body)
+		assert.Equal(t, nil, err)
+		requests <- recordedRequest{encoding: encoding, body: body}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, requests
+}
+
+func TestHTTPSinkCompression(t *testing.T) {
+	testCases := []struct {
+		no     string
+		accept string
+		in     string
+		out    []string
+	}{
+		{"case0", "gzip", encodingGzip, []string{encodingGzip, encodingGzip}},
+		{"case1", "zstd", compressionAuto, []string{"", encodingZstd}},
+		{"case2", "", encodingZstd, []string{"", ""}},
+		{"case3", "gzip", "", []string{"", ""}},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			server, requests := newCompressionServer(t, testCases[i].accept)
+
+			bot := newRobot(&configuration{})
+			s, err := bot.sinkOf(&pluginConfig{Name: "serv1", Endpoint: server.URL, Compression: testCases[i].in})
+			assert.Equal(t, nil, err)
+
+			for _, encoding := range testCases[i].out {
+				assert.Equal(t, nil, s.send(&message{header: http.Header{}, body: []byte(`{"number":"1"}`)}))
+				req := <-requests
+				assert.Equal(t, encoding, req.encoding)
+				assert.Equal(t, `{"number":"1"}`, string(req.body))
+			}
+		})
+	}
+}
+
+func TestServeHTTPCompressed(t *testing.T) {
+	delivered := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		delivered <- body
+	}))
+	defer server.Close()
+
+	bot := newRobot(&configuration{ConfigItems: accessConfig{
+		RepoPlugins: map[string][]string{"ibforuorg": {"serv1"}},
+		Plugins:     []pluginConfig{{Name: "serv1", Endpoint: server.URL, Events: []string{eventComment}}},
+	}})
+	defer bot.wait()
+	data, _ := os.ReadFile(findTestdata(t, "pr_note.json"))
+
+	testCases := []struct {
+		no       string
+		encoding string
+		body     func() []byte
+		out      int
+	}{
+		{"case0", encodingGzip, func() []byte { b, _ := compress(encodingGzip, data); return b }, http.StatusOK},
+		{"case1", encodingZstd, func() []byte { b, _ := compress(encodingZstd, data); return b }, http.StatusOK},
+		{"case2", encodingGzip, func() []byte { return data }, http.StatusBadRequest},
+		{"case3", "br", func() []byte { return data }, http.StatusUnsupportedMediaType},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/compressed", bytes.NewReader(testCases[i].body()))
+			req.Header.Set(headerContentTypeName, headerContentTypeJsonValue)
+			req.Header.Set(headerContentEncoding, testCases[i].encoding)
+			req.Header.Set(headerEventType, headerEventTypeValue)
+			req.Header.Set(headerEventGUID, headerEventGUIDValue)
+			bot.ServeHTTP(w, req)
+
+			assert.Equal(t, testCases[i].out, w.Result().StatusCode)
+			if testCases[i].out == http.StatusOK {
+				evt := client.GenericEvent{}
+				assert.Equal(t, nil, json.Unmarshal(<-delivered, &evt))
+				assert.Equal(t, "test1", *evt.Repo)
+			}
+		})
+	}
+}
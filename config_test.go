@@ -106,6 +106,126 @@ func TestValidate(t *testing.T) {
 			},
 			[]error{nil, nil},
 		},
+		{
+			"case8",
+			args{
+				&configuration{},
+				"config10.yaml",
+			},
+			[]error{nil, errors.New("serv1 plugin has an unknown format xml")},
+		},
+		{
+			"case9",
+			args{
+				&configuration{},
+				"config12.yaml",
+			},
+			[]error{nil, errors.New("serv1 plugin missing the token of subscription")},
+		},
+		{
+			"case10",
+			args{
+				&configuration{},
+				"config13.yaml",
+			},
+			[]error{nil, errors.New(`serv1 plugin has an invalid transform: fields.author: "$.author[" has an unclosed [ at 8`)},
+		},
+		{
+			"case11",
+			args{
+				&configuration{},
+				"config14.yaml",
+			},
+			[]error{nil, errors.New("serv1 plugin: redact rule has an unknown action encrypt")},
+		},
+		{
+			"case12",
+			args{
+				&configuration{},
+				"config15.yaml",
+			},
+			[]error{nil, errors.New("serv1 plugin can not compress the events to a non-http endpoint")},
+		},
+		{
+			"case13",
+			args{
+				&configuration{},
+				"config16.yaml",
+			},
+			[]error{nil, errors.New("rate limit of ibforuorg/test1: the rate must be positive")},
+		},
+		{
+			"case14",
+			args{
+				&configuration{},
+				"config17.yaml",
+			},
+			[]error{nil, errors.New("serv1 plugin in the pull mode can not be rate limited")},
+		},
+		{
+			"case15",
+			args{
+				&configuration{},
+				"config18.yaml",
+			},
+			[]error{nil, errors.New("serv1 plugin has an unknown ordering pr")},
+		},
+		{
+			"case16",
+			args{
+				&configuration{},
+				"config19.yaml",
+			},
+			[]error{nil, errors.New("serv1 plugin has an invalid debounce: unknown forward first")},
+		},
+		{
+			"case17",
+			args{
+				&configuration{},
+				"config20.yaml",
+			},
+			[]error{nil, errors.New("serv1 plugin can not batch the events to a non-http endpoint")},
+		},
+		{
+			"case18",
+			args{
+				&configuration{},
+				"config21.yaml",
+			},
+			[]error{nil, errors.New("serv1 plugin has an invalid health check: invalid url localhost:8080/healthz")},
+		},
+		{
+			"case19",
+			args{
+				&configuration{},
+				"config22.yaml",
+			},
+			[]error{nil, errors.New("serv1 plugin has an unknown balance random")},
+		},
+		{
+			"case20",
+			args{
+				&configuration{},
+				"config23.yaml",
+			},
+			[]error{nil, errors.New("serv1 plugin has an invalid discovery: unknown resolver consul")},
+		},
+		{
+			"case21",
+			args{
+				&configuration{},
+				"config24.yaml",
+			},
+			[]error{nil, errors.New("the drain timeout of the shutdown can not be negative")},
+		},
+		{
+			"case22",
+			args{
+				&configuration{},
+				"config25.yaml",
+			},
+			[]error{nil, errors.New("the drain timeout of the shutdown must be less than 1m0s")},
+		},
 	}
 	for i := range testCases {
 		t.Run(testCases[i].no, func(t *testing.T) {
@@ -201,6 +321,39 @@ func TestGetEndpoints(t *testing.T) {
 			},
 			[]string{"http://localhost:7000/gitcode-hook", "http://localhost:7000/gitcode-hook2"},
 		},
+		{
+			"case6",
+			args{
+				&configuration{},
+				"config9.yaml",
+				"ibforuorg",
+				"test1",
+				"Merge Request Hook",
+			},
+			[]string{"http://localhost:7000/gitcode-hook", "http://localhost:7000/gitcode-hook2"},
+		},
+		{
+			"case7",
+			args{
+				&configuration{},
+				"config9.yaml",
+				"ibforuorg",
+				"test1",
+				"Note Hook",
+			},
+			[]string{"http://localhost:7000/gitcode-hook"},
+		},
+		{
+			"case8",
+			args{
+				&configuration{},
+				"config9.yaml",
+				"ibforuorg",
+				"test1",
+				"Issue Hook",
+			},
+			[]string{"http://localhost:7000/gitcode-hook2"},
+		},
 	}
 	for i := range testCases {
 		t.Run(testCases[i].no, func(t *testing.T) {
@@ -214,6 +367,28 @@ func TestGetEndpoints(t *testing.T) {
 	}
 }
 
+func TestByteSize(t *testing.T) {
+	testCases := []struct {
+		no  string
+		in  string
+		out []any
+	}{
+		{"case0", `1048576`, []any{byteSize(1 << 20), nil}},
+		{"case1", `"1MiB"`, []any{byteSize(1 << 20), nil}},
+		{"case2", `"25 MB"`, []any{byteSize(25e6), nil}},
+		{"case3", `"512B"`, []any{byteSize(512), nil}},
+		{"case4", `"1.5MiB"`, []any{byteSize(0), errors.New(`invalid byte size "1.5MiB"`)}},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			var b byteSize
+			err := b.UnmarshalJSON([]byte(testCases[i].in))
+			assert.Equal(t, testCases[i].out[0], b)
+			assert.Equal(t, testCases[i].out[1], err)
+		})
+	}
+}
+
 func findTestdata(t *testing.T, path string) string {
 	path = "testdata" + string(os.PathSeparator) + path
 	i := 0
@@ -0,0 +1,193 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/opensourceways/robot-framework-lib/utils"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	debounceLatest = "latest"
+	debounceBatch  = "batch"
+
+	// headerRobotCoalesced tells how many events are coalesced into the message
+	headerRobotCoalesced = "Robot-Coalesced-Count"
+
+	contentTypeCloudEventsBatchJSON = "application/cloudevents-batch+json"
+)
+
+// debounceConfig holds the events of a pull request or an issue for a window, and
+// forwards them as one message. The events without a number, eg push, are held by
+// their refs.
+type debounceConfig struct {
+	// Window is how long the events are held from the first one, eg "5s".
+	Window duration `json:"window"`
+
+	// Forward is "latest" which forwards the latest event only, or "batch" which forwards
+	// all of them in a JSON array. The latest one is forwarded if any of them is not JSON.
+	// Defaults to "latest".
+	Forward string `json:"forward,omitempty"`
+
+	// Events are the events to be debounced, either canonical or raw. Defaults to all.
+	Events []string `json:"events,omitempty"`
+}
+
+func (c *debounceConfig) validate() error {
+	if c.Window <= 0 {
+		return errors.New("the window must be positive")
+	}
+
+	if c.Forward != "" && c.Forward != debounceLatest && c.Forward != debounceBatch {
+		return errors.New("unknown forward " + c.Forward)
+	}
+
+	return nil
+}
+
+func (c *debounceConfig) applies(events ...string) bool {
+	return len(c.Events) == 0 || eventMatches(c.Events, events...)
+}
+
+// debouncer holds the messages of the open windows by their keys.
+type debouncer struct {
+	lock    sync.Mutex
+	windows map[string]*debounceWindow
+}
+
+type debounceWindow struct {
+	msgs    []*message
+	forward string
+	flush   func(*message) // flush of the latest message
+}
+
+func newDebouncer() *debouncer {
+	return &debouncer{windows: map[string]*debounceWindow{}}
+}
+
+// add holds the message in the window of the key, and returns true if the window is
+// opened by it. The coalesced message is passed to the flush of the latest message
+// once the window is closed.
+func (b *debouncer) add(key string, msg *message, cfg *debounceConfig, flush func(*message)) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if w, ok := b.windows[key]; ok {
+		w.msgs = append(w.msgs, msg)
+		w.flush = flush
+		return false
+	}
+
+	b.windows[key] = &debounceWindow{msgs: []*message{msg}, forward: cfg.Forward, flush: flush}
+	time.AfterFunc(time.Duration(cfg.Window), func() {
+		b.lock.Lock()
+		w := b.windows[key]
+		delete(b.windows, key)
+		b.lock.Unlock()
+
+		w.flush(coalesce(w.msgs, cfg.Forward))
+	})
+
+	return true
+}
+
+// pending returns the coalesced messages of the open windows.
+func (b *debouncer) pending() []*message {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	msgs := make([]*message, 0, len(b.windows))
+	for _, w := range b.windows {
+		msgs = append(msgs, coalesce(w.msgs, w.forward))
+	}
+
+	return msgs
+}
+
+// coalesce merges the messages into one, which is the latest message with the body of
+// all the messages if they are batched.
+func coalesce(msgs []*message, forward string) *message {
+	latest := *msgs[len(msgs)-1]
+	latest.header = latest.header.Clone()
+	latest.header.Set(headerRobotCoalesced, strconv.Itoa(len(msgs)))
+
+	if forward != debounceBatch || len(msgs) == 1 {
+		return &latest
+	}
+
+	items := make([]json.RawMessage, len(msgs))
+	for i, msg := range msgs {
+		if !json.Valid(msg.body) {
+			return &latest
+		}
+		items[i] = msg.body
+	}
+	body, err := json.Marshal(items)
+	if err != nil {
+		return &latest
+	}
+	latest.body = body
+
+	if latest.header.Get(headerContentType) == contentTypeCloudEventsJSON {
+		latest.header.Set(headerContentType, contentTypeCloudEventsBatchJSON)
+	} else {
+		latest.header.Set(headerContentType, contentTypeJSON)
+	}
+
+	return &latest
+}
+
+// debounceKey is the window of the delivery: its pull request or issue, or its ref,
+// eg the branch of a push, if it has no number. The others are not coalesced.
+func (d *delivery) debounceKey() string {
+	key := d.orderingKey(orderingNumber)
+	if utils.GetString(d.event.Number) != "" {
+		return key
+	}
+
+	var v struct {
+		Ref string `json:"ref"`
+	}
+	if payload, err := d.raw.bytes(); err == nil && json.Unmarshal(payload, &v) == nil && v.Ref != "" {
+		return key + "@" + v.Ref
+	}
+
+	return key + "!" + d.id
+}
+
+// debounce holds the message of the delivery until the window of its pull request,
+// issue or ref is closed.
+func (bot *robot) debounce(d *delivery, p *pluginConfig, s sink, msg *message, logger *logrus.Entry, uri string) {
+	event := msg.header.Get(headerRobotEventType)
+	if event == "" {
+		event = msg.eventType
+	}
+	key := p.Name + ":" + d.debounceKey() + ":" + event
+
+	// the window is flushed before the robot exits
+	bot.wg.Add(1)
+	opened := bot.debouncer.add(key, msg, p.Debounce, func(msg *message) {
+		defer bot.wg.Done()
+
+		bot.send(s, msg, logger.WithField("coalesced", msg.header.Get(headerRobotCoalesced)), uri, func(int, error) {})
+	})
+	if !opened {
+		bot.wg.Done()
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCoalesce(t *testing.T) {
+	newMessage := func(id, contentType, body string) *message {
+		msg := &message{id: id, header: http.Header{}, body: []byte(body)}
+		msg.header.Set(headerContentType, contentType)
+		return msg
+	}
+
+	testCases := []struct {
+		no      string
+		msgs    []*message
+		forward string
+		out     []string
+	}{
+		{
+			"case0",
+			[]*message{newMessage("1", contentTypeJSON, `{"n":1}`), newMessage("2", contentTypeJSON, `{"n":2}`)},
+			debounceLatest,
+			[]string{"2", "2", contentTypeJSON, `{"n":2}`},
+		},
+		{
+			"case1",
+			[]*message{newMessage("1", contentTypeJSON, `{"n":1}`), newMessage("2", contentTypeJSON, `{"n":2}`)},
+			debounceBatch,
+			[]string{"2", "2", contentTypeJSON, `[{"n":1},{"n":2}]`},
+		},
+		{
+			"case2",
+			[]*message{newMessage("1", contentTypeCloudEventsJSON, `{"n":1}`), newMessage("2", contentTypeCloudEventsJSON, `{"n":2}`)},
+			debounceBatch,
+			[]string{"2", "2", contentTypeCloudEventsBatchJSON, `[{"n":1},{"n":2}]`},
+		},
+		{
+			"case3",
+			[]*message{newMessage("1", "text/plain", "n=1"), newMessage("2", "text/plain", "n=2")},
+			debounceBatch,
+			[]string{"2", "2", "text/plain", "n=2"},
+		},
+		{
+			"case4",
+			[]*message{newMessage("1", contentTypeJSON, `{"n":1}`)},
+			debounceBatch,
+			[]string{"1", "1", contentTypeJSON, `{"n":1}`},
+		},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			msg := coalesce(testCases[i].msgs, testCases[i].forward)
+			assert.Equal(t, testCases[i].out[0], msg.id)
+			assert.Equal(t, testCases[i].out[1], msg.header.Get(headerRobotCoalesced))
+			assert.Equal(t, testCases[i].out[2], msg.header.Get(headerContentType))
+			assert.Equal(t, testCases[i].out[3], string(msg.body))
+
+			// the held messages are not changed
+			assert.Equal(t, "", testCases[i].msgs[len(testCases[i].msgs)-1].header.Get(headerRobotCoalesced))
+		})
+	}
+}
+
+func TestDispatchDebounced(t *testing.T) {
+	type request struct {
+		coalesced string
+		body      []byte
+	}
+	received := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- request{coalesced: r.Header.Get(headerRobotCoalesced), body: body}
+	}))
+	defer server.Close()
+
+	bot := newRobot(&configuration{ConfigItems: accessConfig{
+		RepoPlugins: map[string][]string{"ibforuorg": {"serv1"}},
+		Plugins: []pluginConfig{{
+			Name: "serv1", Endpoint: server.URL, Events: []string{eventComment},
+			Debounce: &debounceConfig{Window: duration(200 * time.Millisecond), Forward: debounceBatch},
+		}},
+	}})
+
+	data, _ := os.ReadFile(findTestdata(t, "pr_note.json"))
+	for _, id := range []string{"1", "2", "3"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/debounced", bytes.NewReader(data))
+		req.Header.Set(headerContentTypeName, headerContentTypeJsonValue)
+		req.Header.Set(headerEventType, headerEventTypeValue)
+		req.Header.Set(headerEventGUID, id)
+		bot.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	}
+
+	// the window is flushed before it returns
+	bot.wait()
+	assert.Equal(t, 1, len(received))
+
+	req := <-received
+	assert.Equal(t, "3", req.coalesced)
+	var items []json.RawMessage
+	assert.Equal(t, nil, json.Unmarshal(req.body, &items))
+	assert.Equal(t, 3, len(items))
+}
+
+func TestDebounceKey(t *testing.T) {
+	testCases := []struct {
+		no      string
+		number  string
+		payload string
+		out     string
+	}{
+		{"case0", "4", `{"ref":"refs/heads/main"}`, "ibforuorg/test1#4"},
+		{"case1", "", `{"ref":"refs/heads/main"}`, "ibforuorg/test1@refs/heads/main"},
+		{"case2", "", `{"ref":"refs/heads/dev"}`, "ibforuorg/test1@refs/heads/dev"},
+		{"case3", "", `{"object_kind":"note"}`, "ibforuorg/test1!" + testDeliveryID},
+		{"case4", "", `not json`, "ibforuorg/test1!" + testDeliveryID},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			d := newTestDelivery()
+			d.event.Number = &testCases[i].number
+			d.raw = newMemorySpool([]byte(testCases[i].payload))
+			assert.Equal(t, testCases[i].out, d.debounceKey())
+		})
+	}
+}
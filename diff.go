@@ -0,0 +1,244 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/opensourceways/server-common-lib/utils"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	diffCommand = "diff"
+
+	changeAdded           = "added"
+	changeRemoved         = "removed"
+	changeEndpointChanged = "endpoint_changed"
+)
+
+// routeChange is a change of the routing of the webhooks of an event of a repository.
+type routeChange struct {
+	Repo        string `json:"repo"`
+	Event       string `json:"event"`
+	Plugin      string `json:"plugin"`
+	Change      string `json:"change"`
+	OldEndpoint string `json:"oldEndpoint,omitempty"`
+	NewEndpoint string `json:"newEndpoint,omitempty"`
+}
+
+type diffReport struct {
+	Repos   int            `json:"repos"` // the number of the repositories compared
+	Changes []*routeChange `json:"changes"`
+}
+
+// runDiff compares the routing of two configmaps, it exits with 1 if the routing is
+// changed, or 2 if it fails:
+//
+//	robot-universal-access diff -repos FILE [-format text|json] OLD_CONFIG NEW_CONFIG
+//
+// The repositories are the ones listed in the file, one "org/repo" per line, and the
+// ones bound explicitly by either configmap. Every event subscribed by either configmap
+// is compared.
+func runDiff(args []string, stdout io.Writer) int {
+	fs := flag.NewFlagSet(diffCommand, flag.ContinueOnError)
+	repos := fs.String("repos", "", "the file of the known repositories, one org/repo per line")
+	format := fs.String("format", outputText, "the output format, text or json")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(fs.Output(), "usage: "+diffCommand+" -repos FILE [-format text|json] OLD_CONFIG NEW_CONFIG")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 || *repos == "" || (*format != outputText && *format != outputJSON) {
+		fs.Usage()
+		return 2
+	}
+
+	configs := make([]*configuration, 2)
+	for i, file := range fs.Args() {
+		configs[i] = &configuration{}
+		err := utils.LoadFromYaml(file, configs[i])
+		if err == nil {
+			err = configs[i].Validate()
+		}
+		if err != nil {
+			_, _ = fmt.Fprintln(fs.Output(), "invalid configmap "+file+": "+err.Error())
+			return 2
+		}
+	}
+
+	known, err := readRepos(*repos)
+	if err != nil {
+		_, _ = fmt.Fprintln(fs.Output(), "invalid repos "+*repos+": "+err.Error())
+		return 2
+	}
+
+	report := diffRouting(configs[0], configs[1], known)
+	if *format == outputJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(report)
+	} else {
+		report.print(stdout)
+	}
+
+	if len(report.Changes) > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// readRepos reads the repositories of the file, each of them must be "org/repo".
+func readRepos(path string) ([]string, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range lines {
+		if org, repo, ok := strings.Cut(line, "/"); !ok || org == "" || repo == "" || strings.Contains(repo, "/") {
+			return nil, errors.New(strconv.Quote(line) + " is not org/repo")
+		}
+	}
+
+	return lines, nil
+}
+
+// diffRouting compares the plugins selected by the configmaps for every subscribed
+// event of the repositories.
+func diffRouting(before, after *configuration, repos []string) *diffReport {
+	seen := map[string]bool{}
+	var all []string
+	add := func(repo string) {
+		if !seen[repo] {
+			seen[repo] = true
+			all = append(all, repo)
+		}
+	}
+	for _, repo := range repos {
+		add(repo)
+	}
+	// the repositories bound explicitly are known as well
+	for _, c := range []*configuration{before, after} {
+		for key := range c.ConfigItems.RepoPlugins {
+			if org, repo, ok := strings.Cut(key, "/"); ok && org != "" && repo != "" && !strings.Contains(repo, "/") {
+				add(key)
+			}
+		}
+	}
+	sort.Strings(all)
+
+	events := subscribedEvents(before, after)
+	report := &diffReport{Repos: len(all), Changes: []*routeChange{}}
+	for _, key := range all {
+		org, repo, _ := strings.Cut(key, "/")
+		for _, event := range events {
+			report.Changes = append(report.Changes,
+				diffPlugins(routesOf(before, org, repo, event), routesOf(after, org, repo, event), key, event)...)
+		}
+	}
+
+	return report
+}
+
+// subscribedEvents returns the events subscribed by the plugins of the configmaps, the
+// known ones are converted to the canonical ones and come first in their order.
+func subscribedEvents(configs ...*configuration) []string {
+	seen := map[string]bool{}
+	var raw []string
+	for _, c := range configs {
+		for i := range c.ConfigItems.Plugins {
+			for _, e := range c.ConfigItems.Plugins[i].Events {
+				if canonical, ok := canonicalEventType(e); ok {
+					seen[canonical] = true
+				} else if !seen[e] {
+					seen[e] = true
+					raw = append(raw, e)
+				}
+			}
+		}
+	}
+	sort.Strings(raw)
+
+	var events []string
+	for _, e := range canonicalEvents {
+		if seen[e] {
+			events = append(events, e)
+		}
+	}
+
+	return append(events, raw...)
+}
+
+// routesOf returns the endpoints of the plugins selected for the webhook by their names.
+func routesOf(c *configuration, org, repo, event string) map[string]string {
+	routes := map[string]string{}
+	for _, p := range c.GetPlugins(org, repo, event) {
+		routes[p.Name] = p.location()
+	}
+
+	return routes
+}
+
+func diffPlugins(before, after map[string]string, repo, event string) []*routeChange {
+	var changes []*routeChange
+	for name, endpoint := range after {
+		v, ok := before[name]
+		switch {
+		case !ok:
+			changes = append(changes, &routeChange{Repo: repo, Event: event, Plugin: name, Change: changeAdded, NewEndpoint: endpoint})
+		case v != endpoint:
+			changes = append(changes, &routeChange{
+				Repo: repo, Event: event, Plugin: name, Change: changeEndpointChanged, OldEndpoint: v, NewEndpoint: endpoint,
+			})
+		}
+	}
+	for name, endpoint := range before {
+		if _, ok := after[name]; !ok {
+			changes = append(changes, &routeChange{Repo: repo, Event: event, Plugin: name, Change: changeRemoved, OldEndpoint: endpoint})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Plugin < changes[j].Plugin })
+
+	return changes
+}
+
+func (r *diffReport) print(w io.Writer) {
+	routes := 0
+	for i, c := range r.Changes {
+		if i == 0 || c.Repo != r.Changes[i-1].Repo || c.Event != r.Changes[i-1].Event {
+			routes++
+			_, _ = fmt.Fprintln(w, c.Repo+" "+c.Event)
+		}
+
+		switch c.Change {
+		case changeAdded:
+			_, _ = fmt.Fprintln(w, "  + "+c.Plugin+" "+c.NewEndpoint)
+		case changeRemoved:
+			_, _ = fmt.Fprintln(w, "  - "+c.Plugin+" "+c.OldEndpoint)
+		default:
+			_, _ = fmt.Fprintln(w, "  ~ "+c.Plugin+" "+c.OldEndpoint+" -> "+c.NewEndpoint)
+		}
+	}
+
+	_, _ = fmt.Fprintf(w, "%d change(s) of %d route(s) in %d repo(s)\n", len(r.Changes), routes, r.Repos)
+}
@@ -0,0 +1,104 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiffRouting(t *testing.T) {
+	const (
+		plugin1   = "service-name1"
+		plugin2   = "service-name2"
+		plugin3   = "service-name3"
+		endpoint1 = "http://localhost:7000/gitcode-hook"
+		endpoint3 = "http://localhost:7002/gitcode-hook3"
+		// the event subscribed by the new configmap only
+		pipeline = "Pipeline Hook"
+		old2     = "http://localhost:7000/gitcode-hook2"
+		new2     = "http://localhost:7001/gitcode-hook2"
+	)
+	out := bytes.Buffer{}
+	code := runDiff([]string{"-format", outputJSON, "-repos", findTestdata(t, "repos.txt"),
+		findTestdata(t, "config.yaml"), findTestdata(t, "diff.yaml")}, &out)
+	assert.Equal(t, 1, code)
+
+	report := diffReport{}
+	assert.Equal(t, nil, json.Unmarshal(out.Bytes(), &report))
+	assert.Equal(t, 3, report.Repos)
+	assert.Equal(t, []*routeChange{
+		{"ibforuorg/test1", eventPullRequest, plugin2, changeEndpointChanged, old2, new2},
+		{"ibforuorg/test1", eventIssue, plugin2, changeEndpointChanged, old2, new2},
+		{"ibforuorg/test1", eventComment, plugin2, changeEndpointChanged, old2, new2},
+		{"ibforuorg/test1", eventPush, plugin1, changeRemoved, endpoint1, ""},
+		{"ibforuorg/test1", eventPush, plugin2, changeEndpointChanged, old2, new2},
+		{"ibforuorg/test1", pipeline, plugin3, changeAdded, "", endpoint3},
+		{"ibforuorg/test2", eventPullRequest, plugin1, changeAdded, "", endpoint1},
+		{"ibforuorg/test2", eventIssue, plugin1, changeAdded, "", endpoint1},
+		{"ibforuorg/test2", eventComment, plugin1, changeAdded, "", endpoint1},
+		{"ibforuorg/test2", pipeline, plugin3, changeAdded, "", endpoint3},
+	}, report.Changes)
+}
+
+func TestRunDiff(t *testing.T) {
+	dir := t.TempDir()
+	invalidRepos := filepath.Join(dir, "repos.txt")
+	_ = os.WriteFile(invalidRepos, []byte("ibforuorg\n"), 0o600)
+
+	repos, config := findTestdata(t, "repos.txt"), findTestdata(t, "config.yaml")
+	testCases := []struct {
+		no   string
+		args []string
+		code int
+		out  string
+	}{
+		{"case0", []string{"-repos", repos, config, config}, 0, "0 change(s) of 0 route(s) in 3 repo(s)\n"},
+		{"case1", []string{"-repos", repos, config, findTestdata(t, "diff.yaml")}, 1, ""},
+		{"case2", []string{config, config}, 2, ""},
+		{"case3", []string{"-repos", repos, config}, 2, ""},
+		{"case4", []string{"-repos", repos, config, findTestdata(t, "config23.yaml")}, 2, ""},
+		{"case5", []string{"-repos", invalidRepos, config, config}, 2, ""},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			out := bytes.Buffer{}
+			assert.Equal(t, testCases[i].code, runDiff(testCases[i].args, &out))
+			if testCases[i].out != "" {
+				assert.Equal(t, testCases[i].out, out.String())
+			}
+		})
+	}
+}
+
+func TestDiffReportPrint(t *testing.T) {
+	out := bytes.Buffer{}
+	report := diffReport{Repos: 1, Changes: []*routeChange{
+		{"ibforuorg/test1", eventPush, "serv1", changeRemoved, "http://a", ""},
+		{"ibforuorg/test1", eventPush, "serv2", changeEndpointChanged, "http://b", "http://c"},
+		{"ibforuorg/test1", eventTag, "serv3", changeAdded, "", "http://d"},
+	}}
+	report.print(&out)
+
+	assert.Equal(t, "ibforuorg/test1 push\n"+
+		"  - serv1 http://a\n"+
+		"  ~ serv2 http://b -> http://c\n"+
+		"ibforuorg/test1 tag\n"+
+		"  + serv3 http://d\n"+
+		"3 change(s) of 2 route(s) in 1 repo(s)\n", out.String())
+}
@@ -0,0 +1,261 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	resolverDNSSRV = "dns-srv"
+	resolverFile   = "file"
+
+	defaultDiscoveryScheme  = "http"
+	defaultDiscoveryRefresh = 30 * time.Second
+	discoveryResolveTimeout = 5 * time.Second
+)
+
+// discoveryConfig resolves the endpoints of a plugin dynamically, they are refreshed
+// periodically and balanced by the Balance strategy of the plugin.
+type discoveryConfig struct {
+	// Resolver is "dns-srv" which looks up the SRV records of Name, eg the Kubernetes
+	// Service "_http._tcp.plugin.robots.svc.cluster.local", or "file" which reads the
+	// endpoints from the file Name, one per line.
+	Resolver string `json:"resolver"`
+
+	// Name is the SRV name or the path of the file.
+	Name string `json:"name"`
+
+	// Scheme of the endpoints resolved from the SRV records. Defaults to "http".
+	Scheme string `json:"scheme,omitempty"`
+
+	// Path of the endpoints resolved from the SRV records, eg "/gitcode-hook".
+	Path string `json:"path,omitempty"`
+
+	// Refresh is the interval of resolving the endpoints. Defaults to "30s".
+	Refresh duration `json:"refresh,omitempty"`
+}
+
+func (c *discoveryConfig) validate() error {
+	if _, ok := resolverFactories[c.Resolver]; !ok {
+		return errors.New("unknown resolver " + c.Resolver)
+	}
+
+	if c.Name == "" {
+		return errors.New("missing name")
+	}
+
+	if _, ok := sinkFactories[c.scheme()]; !ok {
+		return errors.New("unsupported scheme " + c.Scheme)
+	}
+
+	if c.Refresh < 0 {
+		return errors.New("the refresh can not be negative")
+	}
+
+	return nil
+}
+
+func (c *discoveryConfig) scheme() string {
+	if c.Scheme == "" {
+		return defaultDiscoveryScheme
+	}
+	return c.Scheme
+}
+
+// resolver resolves the endpoints of a plugin.
+type resolver interface {
+	resolve(ctx context.Context) ([]string, error)
+}
+
+// resolverFactories creates the resolver by its name in discoveryConfig.
+var resolverFactories = map[string]func(c *discoveryConfig, log *logrus.Entry) resolver{
+	resolverDNSSRV: func(c *discoveryConfig, _ *logrus.Entry) resolver {
+		return &srvResolver{cfg: c, lookupSRV: net.DefaultResolver.LookupSRV}
+	},
+	resolverFile: func(c *discoveryConfig, log *logrus.Entry) resolver {
+		return &fileResolver{path: c.Name, log: log}
+	},
+}
+
+// srvResolver resolves the endpoints by the DNS SRV records, which are ordered by
+// their priorities and targets. The order of the lookup is not used, as the records
+// of the same priority are shuffled by their weights, which would reorder the
+// endpoints on every refresh.
+type srvResolver struct {
+	cfg       *discoveryConfig
+	lookupSRV func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+func (r *srvResolver) resolve(ctx context.Context) ([]string, error) {
+	_, records, err := r.lookupSRV(ctx, "", "", r.cfg.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Priority != records[j].Priority {
+			return records[i].Priority < records[j].Priority
+		}
+		if records[i].Target != records[j].Target {
+			return records[i].Target < records[j].Target
+		}
+		return records[i].Port < records[j].Port
+	})
+
+	endpoints := make([]string, len(records))
+	for i, srv := range records {
+		host := net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+		endpoints[i] = r.cfg.scheme() + "://" + host + r.cfg.Path
+	}
+
+	return endpoints, nil
+}
+
+// fileResolver reads the endpoints from the file, the empty lines and the ones
+// starting with "#" are ignored. The malformed endpoints are logged and skipped,
+// so that a typo does not stop the refreshing of the others.
+type fileResolver struct {
+	path string
+	log  *logrus.Entry
+}
+
+func (r *fileResolver) resolve(context.Context) ([]string, error) {
+	lines, err := readLines(r.path)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := lines[:0]
+	for _, line := range lines {
+		if err := validateEndpoint(line); err != nil {
+			r.log.WithField("file", r.path).WithError(err).Warning("skip the malformed endpoint")
+			continue
+		}
+		endpoints = append(endpoints, line)
+	}
+
+	return endpoints, nil
+}
+
+// readLines reads the lines of the file, the empty lines and the ones starting with
+// "#" are ignored.
+func readLines(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+// discoveredSink balances the messages over the resolved endpoints, which are
+// refreshed until it is closed.
+type discoveredSink struct {
+	*balancedSink
+	resolver resolver
+	create   func(endpoint string) (sink, error)
+	log      *logrus.Entry
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+func newDiscoveredSink(bot *robot, p *pluginConfig) (*discoveredSink, error) {
+	s := &discoveredSink{
+		balancedSink: newBalancedSink(p, nil, nil),
+		resolver:     resolverFactories[p.Discovery.Resolver](p.Discovery, bot.log.WithField("plugin", p.Name)),
+		create: func(endpoint string) (sink, error) {
+			if err := validateEndpoint(endpoint); err != nil {
+				return nil, err
+			}
+			return newEndpointSink(bot, p, endpoint)
+		},
+		log:     bot.log.WithField("plugin", p.Name),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+
+	go s.run(p.Discovery.Refresh.or(defaultDiscoveryRefresh))
+
+	return s, nil
+}
+
+func (s *discoveredSink) run(interval time.Duration) {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		// the endpoints resolved last time are kept if it fails
+		if err := s.refresh(); err != nil {
+			s.log.WithError(err).Error("failed to resolve the endpoints")
+		}
+	}
+}
+
+// refresh resolves the endpoints, and updates the balanced ones.
+func (s *discoveredSink) refresh() error {
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryResolveTimeout)
+	defer cancel()
+
+	endpoints, err := s.resolver.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	if len(endpoints) == 0 {
+		return errors.New("no endpoint is resolved")
+	}
+
+	unused, err := s.update(endpoints, s.create)
+	for _, u := range unused {
+		_ = u.close()
+	}
+
+	return err
+}
+
+func (s *discoveredSink) close() error {
+	close(s.stop)
+	<-s.stopped
+
+	return s.balancedSink.close()
+}
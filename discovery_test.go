@@ -0,0 +1,128 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSRVResolver(t *testing.T) {
+	name := "_http._tcp.plugin.robots.svc.cluster.local"
+	r := &srvResolver{
+		cfg: &discoveryConfig{Resolver: resolverDNSSRV, Name: name, Path: "/hook"},
+		lookupSRV: func(_ context.Context, service, proto, n string) (string, []*net.SRV, error) {
+			assert.Equal(t, "", service)
+			assert.Equal(t, "", proto)
+			if n != name {
+				return "", nil, errors.New("no such host")
+			}
+			// the order of the lookup is ignored
+			return n, []*net.SRV{
+				{Target: "10-0-0-3.plugin.robots.svc.cluster.local.", Port: 8080, Priority: 1},
+				{Target: "10-0-0-2.plugin.robots.svc.cluster.local.", Port: 8080, Weight: 10},
+				{Target: "10-0-0-1.plugin.robots.svc.cluster.local.", Port: 8080, Weight: 20},
+			}, nil
+		},
+	}
+
+	endpoints, err := r.resolve(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{
+		"http://10-0-0-1.plugin.robots.svc.cluster.local:8080/hook",
+		"http://10-0-0-2.plugin.robots.svc.cluster.local:8080/hook",
+		"http://10-0-0-3.plugin.robots.svc.cluster.local:8080/hook",
+	}, endpoints)
+
+	r.cfg.Name = "unknown"
+	_, err = r.resolve(context.Background())
+	assert.Equal(t, errors.New("no such host"), err)
+}
+
+func TestFileResolver(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "endpoints")
+	assert.Equal(t, nil, os.WriteFile(file, []byte("http://10.0.0.1:8080/hook\n10.0.0.2:8080\ngrpc://10.0.0.3:9000\n"), 0o600))
+
+	// the malformed line is skipped instead of failing the others
+	r := &fileResolver{path: file, log: logrus.NewEntry(logrus.New())}
+	endpoints, err := r.resolve(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{"http://10.0.0.1:8080/hook", "grpc://10.0.0.3:9000"}, endpoints)
+}
+
+func TestDiscoveredSink(t *testing.T) {
+	received := make(chan string, 10)
+	handler := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received <- name
+		})
+	}
+	server1 := httptest.NewServer(handler("replica1"))
+	defer server1.Close()
+	server2 := httptest.NewServer(handler("replica2"))
+	defer server2.Close()
+
+	file := filepath.Join(t.TempDir(), "endpoints")
+	assert.Equal(t, nil, os.WriteFile(file, []byte("# the replicas\n"+server1.URL+"\n\n"), 0o600))
+
+	bot := newRobot(&configuration{ConfigItems: accessConfig{Plugins: []pluginConfig{{
+		Name:      "serv1",
+		Balance:   balanceFailover,
+		Discovery: &discoveryConfig{Resolver: resolverFile, Name: file, Refresh: duration(10 * time.Millisecond)},
+	}}}})
+	defer bot.wait()
+
+	p := &bot.configmap.ConfigItems.Plugins[0]
+	assert.Equal(t, "file:"+file, p.location())
+	s, err := bot.sinkOf(p)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, s.send(&message{header: http.Header{}, body: []byte("{}")}))
+	assert.Equal(t, "replica1", <-received)
+
+	// the new endpoints are picked up by the refreshing, and the primary is replica2
+	assert.Equal(t, nil, os.WriteFile(file, []byte(server2.URL+"\n"+server1.URL+"\n"), 0o600))
+	d := s.(*discoveredSink)
+	assert.Eventually(t, func() bool {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		return len(d.endpoints) == 2
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, nil, s.send(&message{header: http.Header{}, body: []byte("{}")}))
+	assert.Equal(t, "replica2", <-received)
+
+	// the endpoints are kept if the resolving fails
+	assert.Equal(t, nil, os.Remove(file))
+	assert.NotEqual(t, nil, d.refresh())
+	assert.Equal(t, 2, len(d.pick(&message{})))
+}
+
+func TestDiscoveredSinkUnresolved(t *testing.T) {
+	bot := newRobot(&configuration{ConfigItems: accessConfig{Plugins: []pluginConfig{{
+		Name:      "serv1",
+		Discovery: &discoveryConfig{Resolver: resolverFile, Name: filepath.Join(t.TempDir(), "missing")},
+	}}}})
+
+	_, err := bot.sinkOf(&bot.configmap.ConfigItems.Plugins[0])
+	assert.NotEqual(t, nil, err)
+	assert.Equal(t, 0, len(bot.sinks))
+}
@@ -0,0 +1,165 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+)
+
+// canonical event types, independent of the forge which sent the webhook
+const (
+	eventPullRequest = "pull_request"
+	eventIssue       = "issue"
+	eventComment     = "comment"
+	eventPush        = "push"
+	eventTag         = "tag"
+	eventRelease     = "release"
+)
+
+var canonicalEvents = []string{eventPullRequest, eventIssue, eventComment, eventPush, eventTag, eventRelease}
+
+const (
+	platformGitCode = "gitcode"
+	platformGitee   = "gitee"
+	platformGitHub  = "github"
+)
+
+// platformEventHeaders is the header carrying the raw event type of each platform.
+var platformEventHeaders = map[string]string{
+	platformGitCode: "X-GitCode-Event",
+	platformGitee:   "X-Gitee-Event",
+	platformGitHub:  "X-GitHub-Event",
+}
+
+// eventAliases maps the raw event type of each platform to the canonical one.
+var eventAliases = map[string]map[string]string{
+	platformGitCode: {
+		"Merge Request Hook": eventPullRequest,
+		"Issue Hook":         eventIssue,
+		"Note Hook":          eventComment,
+		"Push Hook":          eventPush,
+		"Tag Push Hook":      eventTag,
+		"Release Hook":       eventRelease,
+	},
+	platformGitee: {
+		"Merge Request Hook": eventPullRequest,
+		"Issue Hook":         eventIssue,
+		"Note Hook":          eventComment,
+		"Push Hook":          eventPush,
+		"Tag Push Hook":      eventTag,
+	},
+	platformGitHub: {
+		"pull_request":                eventPullRequest,
+		"issues":                      eventIssue,
+		"issue_comment":               eventComment,
+		"pull_request_review_comment": eventComment,
+		"push":                        eventPush,
+		"release":                     eventRelease,
+	},
+}
+
+// githubCreateEvent is sent by GitHub on creating either a branch or a tag.
+const githubCreateEvent = "create"
+
+// routedEventTypes returns the event types which the webhook is routed by. It is the raw
+// one, and the tag event as well for the GitHub create event of a tag, so both the plugins
+// subscribing the raw and the canonical event types receive it.
+func routedEventTypes(eventType string, payload []byte) []string {
+	if eventType != githubCreateEvent {
+		return []string{eventType}
+	}
+
+	var v struct {
+		RefType string `json:"ref_type"`
+	}
+	if json.Unmarshal(payload, &v) == nil && v.RefType == "tag" {
+		return []string{eventType, eventTag}
+	}
+
+	return []string{eventType}
+}
+
+// canonicalOf returns the canonical event type of the routed event types, if any.
+func canonicalOf(eventTypes []string) (canonical string, ok bool) {
+	for i := len(eventTypes) - 1; i >= 0; i-- {
+		if canonical, ok = canonicalEventType(eventTypes[i]); ok {
+			return
+		}
+	}
+
+	return "", false
+}
+
+// platformOf detects the platform which sent the webhook by its event header.
+// It returns an empty string if the platform is unknown.
+func platformOf(h http.Header) string {
+	for _, platform := range []string{platformGitCode, platformGitee, platformGitHub} {
+		if h.Get(platformEventHeaders[platform]) != "" {
+			return platform
+		}
+	}
+
+	return ""
+}
+
+// canonicalEventType converts a raw event type of any platform to the canonical one.
+// A canonical event type is returned as is. ok is false if the name is unknown.
+func canonicalEventType(name string) (canonical string, ok bool) {
+	if slices.Contains(canonicalEvents, name) {
+		return name, true
+	}
+
+	for _, aliases := range eventAliases {
+		if canonical, ok = aliases[name]; ok {
+			return
+		}
+	}
+
+	return "", false
+}
+
+// knownEventType reports whether the name is a canonical event or a raw event type
+// of any platform, which the plugins can subscribe.
+func knownEventType(name string) bool {
+	if _, ok := canonicalEventType(name); ok {
+		return true
+	}
+
+	return name == githubCreateEvent
+}
+
+// eventMatches reports whether any of the event types is one of the events, comparing
+// the canonical event types if both of them are known.
+func eventMatches(events []string, eventTypes ...string) bool {
+	for _, event := range eventTypes {
+		if slices.Contains(events, event) {
+			return true
+		}
+
+		canonical, ok := canonicalEventType(event)
+		if !ok {
+			continue
+		}
+
+		for _, item := range events {
+			if v, known := canonicalEventType(item); known && v == canonical {
+				return true
+			}
+		}
+	}
+
+	return false
+}
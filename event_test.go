@@ -0,0 +1,116 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCanonicalEventType(t *testing.T) {
+	testCases := []struct {
+		no  string
+		in  string
+		out []any
+	}{
+		{"case0", "", []any{"", false}},
+		{"case1", "Merge Request Hook", []any{eventPullRequest, true}},
+		{"case2", "Note Hook", []any{eventComment, true}},
+		{"case3", "issue_comment", []any{eventComment, true}},
+		{"case4", "push", []any{eventPush, true}},
+		{"case5", "release", []any{eventRelease, true}},
+		{"case6", "Dummy Hook", []any{"", false}},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			canonical, ok := canonicalEventType(testCases[i].in)
+			assert.Equal(t, testCases[i].out[0], canonical)
+			assert.Equal(t, testCases[i].out[1], ok)
+		})
+	}
+}
+
+func TestEventMatches(t *testing.T) {
+	type args struct {
+		events []string
+		event  string
+	}
+
+	testCases := []struct {
+		no  string
+		in  args
+		out bool
+	}{
+		{"case0", args{nil, "Note Hook"}, false},
+		{"case1", args{[]string{"Note Hook"}, "Note Hook"}, true},
+		{"case2", args{[]string{"comment"}, "Note Hook"}, true},
+		{"case3", args{[]string{"issue_comment"}, "Note Hook"}, true},
+		{"case4", args{[]string{"Issue Hook", "push"}, "Note Hook"}, false},
+		{"case5", args{[]string{"Dummy Hook"}, "Dummy Hook"}, true},
+		{"case6", args{[]string{"Dummy Hook"}, "Other Hook"}, false},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			assert.Equal(t, testCases[i].out, eventMatches(testCases[i].in.events, testCases[i].in.event))
+		})
+	}
+}
+
+func TestRoutedEventTypes(t *testing.T) {
+	type args struct {
+		event   string
+		payload string
+	}
+
+	testCases := []struct {
+		no  string
+		in  args
+		out []string
+	}{
+		{"case0", args{"create", `{"ref":"v1.0","ref_type":"tag"}`}, []string{"create", eventTag}},
+		{"case1", args{"create", `{"ref":"main","ref_type":"branch"}`}, []string{"create"}},
+		{"case2", args{"create", `not json`}, []string{"create"}},
+		{"case3", args{"Tag Push Hook", `{"ref_type":"tag"}`}, []string{"Tag Push Hook"}},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			assert.Equal(t, testCases[i].out, routedEventTypes(testCases[i].in.event, []byte(testCases[i].in.payload)))
+		})
+	}
+}
+
+func TestGetEndpointsCreate(t *testing.T) {
+	c := &configuration{ConfigItems: accessConfig{
+		RepoPlugins: map[string][]string{"ibforuorg": {"raw", "canonical"}},
+		Plugins: []pluginConfig{
+			{Name: "raw", Endpoint: "http://localhost:8080/raw", Events: []string{"create"}},
+			{Name: "canonical", Endpoint: "http://localhost:8080/canonical", Events: []string{"tag"}},
+		},
+	}}
+
+	testCases := []struct {
+		no  string
+		in  string
+		out []string
+	}{
+		{"case0", `{"ref":"v1.0","ref_type":"tag"}`, []string{"http://localhost:8080/raw", "http://localhost:8080/canonical"}},
+		{"case1", `{"ref":"main","ref_type":"branch"}`, []string{"http://localhost:8080/raw"}},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			endpoints := c.GetEndpoints("ibforuorg", "test1", routedEventTypes("create", []byte(testCases[i].in))...)
+			assert.Equal(t, testCases[i].out, endpoints)
+		})
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"github.com/opensourceways/robot-framework-lib/client"
+	"github.com/opensourceways/robot-framework-lib/utils"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	formatGeneric               = "generic"
+	formatCloudEventsBinary     = "cloudevents-binary"
+	formatCloudEventsStructured = "cloudevents-structured"
+)
+
+var payloadFormats = []string{formatGeneric, formatCloudEventsBinary, formatCloudEventsStructured}
+
+const (
+	payloadGeneric = "generic"
+	payloadRaw     = "raw"
+)
+
+const (
+	cloudEventsSpecVersion = "1.0"
+	cloudEventsTypePrefix  = "com.opensourceways.robot."
+
+	headerContentType            = "Content-Type"
+	contentTypeJSON              = "application/json"
+	contentTypeCloudEventsJSON   = "application/cloudevents+json; charset=utf-8"
+	headerCloudEventsSpecVersion = "Ce-Specversion"
+	headerCloudEventsType        = "Ce-Type"
+	headerCloudEventsSource      = "Ce-Source"
+	headerCloudEventsID          = "Ce-Id"
+	headerCloudEventsTime        = "Ce-Time"
+)
+
+// message is what exactly will be delivered to a plugin.
+type message struct {
+	header    http.Header
+	body      []byte
+	stream    *spool // the body is streamed from the spilled raw payload instead, if set
+	id        string
+	eventType string
+	org       string
+	repo      string
+	plugin    string // the name of the plugin it is sent to
+}
+
+// payload returns the body, which is read from the file if it is streamed.
+func (m *message) payload() ([]byte, error) {
+	if m.stream == nil {
+		return m.body, nil
+	}
+
+	return m.stream.bytes()
+}
+
+// key is the key of the message when it is published to a message broker.
+func (m *message) key() string {
+	return m.org + "/" + m.repo
+}
+
+// cloudEvent is a CloudEvents 1.0 event in the structured content mode.
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	Type            string          `json:"type"`
+	Source          string          `json:"source"`
+	ID              string          `json:"id"`
+	Time            string          `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      []byte          `json:"data_base64,omitempty"` // the data which is not JSON
+}
+
+// encode builds the message of the delivery in the format required by the plugin.
+func (d *delivery) encode(p *pluginConfig) (*message, error) {
+	msg := &message{
+		header:    d.header.Clone(),
+		id:        d.id,
+		eventType: utils.GetString(d.event.EventType),
+		org:       utils.GetString(d.event.Org),
+		repo:      utils.GetString(d.event.Repo),
+		plugin:    p.Name,
+	}
+	contentType := contentTypeJSON
+	if p.Payload == payloadRaw {
+		// forward the original webhook, so the plugin is able to verify its signature
+		if d.raw.spilled() && p.streamsRaw() {
+			msg.stream = d.raw
+		} else {
+			body, err := d.raw.bytes()
+			if err != nil {
+				return nil, err
+			}
+			msg.body = body
+		}
+		if v := d.header.Get(headerContentType); v != "" {
+			contentType = v
+		}
+	} else {
+		body, err := json.Marshal(d.event)
+		if err != nil {
+			return nil, err
+		}
+		msg.body = body
+		msg.header.Set(client.HeaderRobotChain, client.HeaderRobotChainAuthed)
+	}
+
+	// the redacted fields must not be exposed by the transformation, the raw payload
+	// is kept byte for byte instead
+	rules := append(d.redact[:len(d.redact):len(d.redact)], p.Redact...)
+	if p.Payload != payloadRaw && len(rules) > 0 {
+		body, err := redactJSON(msg.body, rules)
+		if err != nil {
+			return nil, err
+		}
+		msg.body = body
+	}
+
+	if p.Transform != nil {
+		v, err := p.Transform.apply(msg)
+		if err != nil {
+			return nil, err
+		}
+		// the body is neither a GenericEvent nor the original webhook any more
+		if v != "" {
+			contentType = v
+			msg.header.Del(client.HeaderRobotChain)
+		}
+	}
+
+	switch p.Format {
+	case formatCloudEventsBinary:
+		ce := d.cloudEvent(contentType)
+		msg.header.Set(headerCloudEventsSpecVersion, ce.SpecVersion)
+		msg.header.Set(headerCloudEventsType, ce.Type)
+		msg.header.Set(headerCloudEventsSource, ce.Source)
+		msg.header.Set(headerCloudEventsID, ce.ID)
+		msg.header.Set(headerCloudEventsTime, ce.Time)
+		msg.header.Set(headerContentType, contentType)
+	case formatCloudEventsStructured:
+		ce := d.cloudEvent(contentType)
+		if isJSONContentType(contentType) && json.Valid(msg.body) {
+			ce.Data = msg.body
+		} else {
+			ce.DataBase64 = msg.body
+		}
+		body, err := json.Marshal(ce)
+		if err != nil {
+			return nil, err
+		}
+		msg.body = body
+		msg.header.Set(headerContentType, contentTypeCloudEventsJSON)
+	default:
+		msg.header.Set(headerContentType, contentType)
+	}
+
+	return msg, nil
+}
+
+// cloudEvent fills in the context attributes of the delivery, without data.
+func (d *delivery) cloudEvent(dataContentType string) *cloudEvent {
+	// the canonical event type is set on accepting the webhook, as it may depend on the payload
+	eventType := d.header.Get(headerRobotEventType)
+	if eventType == "" {
+		eventType = utils.GetString(d.event.EventType)
+		if canonical, ok := canonicalEventType(eventType); ok {
+			eventType = canonical
+		}
+	}
+
+	platform := platformOf(d.header)
+	if platform == "" {
+		// the framework only parses the webhooks of GitCode
+		platform = platformGitCode
+	}
+
+	return &cloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		Type:            cloudEventsTypePrefix + eventType,
+		Source:          platform + "/" + utils.GetString(d.event.Org) + "/" + utils.GetString(d.event.Repo),
+		ID:              d.id,
+		Time:            d.received.UTC().Format(time.RFC3339Nano),
+		DataContentType: dataContentType,
+	}
+}
+
+// isJSONContentType reports whether the media type is JSON, eg "application/json"
+// or "application/vnd.api+json".
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == contentTypeJSON || strings.HasSuffix(mediaType, "+json")
+}
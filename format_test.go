@@ -0,0 +1,123 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"github.com/opensourceways/robot-framework-lib/client"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const testDeliveryID = "4f0c2b7e-9a31-4d5c-8e6f-1b2a3c4d5e6f"
+
+func newTestDelivery() *delivery {
+	eventType, guid, org, repo := headerEventTypeValue, headerEventGUIDValue, "ibforuorg", "test1"
+	h := http.Header{}
+	h.Set(headerEventType, headerEventTypeValue)
+	h.Set(headerEventGUID, headerEventGUIDValue)
+	h.Set(headerContentTypeName, headerContentTypeJsonValue+"; charset=utf-8")
+
+	d := newDelivery(testDeliveryID,
+		&client.GenericEvent{EventType: &eventType, EventGUID: &guid, Org: &org, Repo: &repo}, h,
+		newMemorySpool([]byte(`{"object_kind":"note","unknown":1}`)),
+	)
+	d.received = time.Date(2024, 10, 1, 8, 0, 0, 0, time.UTC)
+	return d
+}
+
+func TestEncode(t *testing.T) {
+	d := newTestDelivery()
+	data, _ := json.Marshal(d.event)
+
+	msg, err := d.encode(&pluginConfig{Name: "serv1"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, data, msg.body)
+	assert.Equal(t, contentTypeJSON, msg.header.Get(headerContentType))
+	assert.Equal(t, headerEventTypeValue, msg.header.Get(headerEventType))
+	assert.Equal(t, headerRobotChainAuthed, msg.header.Get(headerRobotChain))
+	assert.Equal(t, "", msg.header.Get(headerCloudEventsID))
+
+	msg, err = d.encode(&pluginConfig{Name: "serv1", Payload: payloadRaw})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, d.raw.data, msg.body)
+	assert.Equal(t, headerContentTypeJsonValue+"; charset=utf-8", msg.header.Get(headerContentType))
+	assert.Equal(t, headerEventGUIDValue, msg.header.Get(headerEventGUID))
+	assert.Equal(t, "", msg.header.Get(headerRobotChain))
+
+	msg, err = d.encode(&pluginConfig{Name: "serv1", Payload: payloadRaw, Format: formatCloudEventsStructured})
+	assert.Equal(t, nil, err)
+	ce := cloudEvent{}
+	assert.Equal(t, nil, json.Unmarshal(msg.body, &ce))
+	assert.Equal(t, json.RawMessage(d.raw.data), ce.Data)
+	assert.Equal(t, headerContentTypeJsonValue+"; charset=utf-8", ce.DataContentType)
+
+	msg, err = d.encode(&pluginConfig{Name: "serv1", Format: formatCloudEventsBinary})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, data, msg.body)
+	assert.Equal(t, contentTypeJSON, msg.header.Get(headerContentType))
+	assert.Equal(t, cloudEventsSpecVersion, msg.header.Get(headerCloudEventsSpecVersion))
+	assert.Equal(t, cloudEventsTypePrefix+eventComment, msg.header.Get(headerCloudEventsType))
+	assert.Equal(t, "gitcode/ibforuorg/test1", msg.header.Get(headerCloudEventsSource))
+	assert.Equal(t, testDeliveryID, msg.header.Get(headerCloudEventsID))
+	assert.Equal(t, "2024-10-01T08:00:00Z", msg.header.Get(headerCloudEventsTime))
+
+	msg, err = d.encode(&pluginConfig{Name: "serv1", Format: formatCloudEventsStructured})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, contentTypeCloudEventsJSON, msg.header.Get(headerContentType))
+	ce = cloudEvent{}
+	assert.Equal(t, nil, json.Unmarshal(msg.body, &ce))
+	assert.Equal(t, cloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		Type:            cloudEventsTypePrefix + eventComment,
+		Source:          "gitcode/ibforuorg/test1",
+		ID:              testDeliveryID,
+		Time:            "2024-10-01T08:00:00Z",
+		DataContentType: contentTypeJSON,
+		Data:            data,
+	}, ce)
+
+	// the headers of the delivery are never changed by the encoding
+	assert.Equal(t, headerContentTypeJsonValue+"; charset=utf-8", d.header.Get(headerContentTypeName))
+	assert.Equal(t, "", d.header.Get(headerCloudEventsID))
+}
+
+func TestEncodeCloudEventsNotJSON(t *testing.T) {
+	form := []byte("payload=%7B%22action%22%3A%22opened%22%7D")
+	d := newTestDelivery()
+	d.raw = newMemorySpool(form)
+	d.header.Set(headerContentTypeName, "application/x-www-form-urlencoded")
+
+	// the raw payload of a form encoded webhook is not JSON
+	msg, err := d.encode(&pluginConfig{Name: "serv1", Payload: payloadRaw, Format: formatCloudEventsStructured})
+	assert.Equal(t, nil, err)
+	ce := cloudEvent{}
+	assert.Equal(t, nil, json.Unmarshal(msg.body, &ce))
+	assert.Equal(t, "application/x-www-form-urlencoded", ce.DataContentType)
+	assert.Equal(t, json.RawMessage(nil), ce.Data)
+	assert.Equal(t, form, ce.DataBase64)
+
+	// nor is the text rendered by a template
+	msg, err = newTestDelivery().encode(&pluginConfig{
+		Name: "serv1", Payload: payloadRaw, Format: formatCloudEventsStructured,
+		Transform: &transformConfig{Template: `kind={{.object_kind}}`, ContentType: "text/plain"},
+	})
+	assert.Equal(t, nil, err)
+	ce = cloudEvent{}
+	assert.Equal(t, nil, json.Unmarshal(msg.body, &ce))
+	assert.Equal(t, "text/plain", ce.DataContentType)
+	assert.Equal(t, []byte("kind=note"), ce.DataBase64)
+}
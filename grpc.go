@@ -0,0 +1,261 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"errors"
+	deliveryv1 "github.com/opensourceways/robot-universal-access/api/delivery/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const grpcDeliverTimeout = 10 * time.Second
+
+// grpcSink delivers the messages to a plugin implementing the Delivery service.
+// The messages are sent over a long-lived stream, unless the plugin implements
+// the unary method only.
+type grpcSink struct {
+	conn   *grpc.ClientConn
+	client deliveryv1.DeliveryClient
+	health healthpb.HealthClient
+
+	lock   sync.Mutex
+	stream *grpcStream
+	unary  bool
+}
+
+func newGRPCSink(opts ...grpc.DialOption) sinkFactory {
+	return func(_ *robot, _ *pluginConfig, u *url.URL) (sink, error) {
+		conn, err := grpc.NewClient("passthrough:///"+u.Host, opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		return &grpcSink{
+			conn:   conn,
+			client: deliveryv1.NewDeliveryClient(conn),
+			health: healthpb.NewHealthClient(conn),
+		}, nil
+	}
+}
+
+func (s *grpcSink) send(msg *message) error {
+	evt := &deliveryv1.Event{
+		Id:                 msg.id,
+		EventType:          msg.eventType,
+		CanonicalEventType: msg.header.Get(headerRobotEventType),
+		Org:                msg.org,
+		Repo:               msg.repo,
+		Headers:            make(map[string]string, len(msg.header)),
+		ContentType:        msg.header.Get(headerContentType),
+		Payload:            msg.body,
+	}
+	for k := range msg.header {
+		evt.Headers[k] = msg.header.Get(k)
+	}
+
+	return withRetry(func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), grpcDeliverTimeout)
+		defer cancel()
+
+		return s.deliver(ctx, evt)
+	})
+}
+
+func (s *grpcSink) deliver(ctx context.Context, evt *deliveryv1.Event) error {
+	s.lock.Lock()
+	unary := s.unary
+	s.lock.Unlock()
+
+	if unary {
+		ack, err := s.client.Deliver(ctx, evt)
+		return ackError(ack, err)
+	}
+
+	stream, err := s.openStream(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = stream.deliver(ctx, evt)
+	if status.Code(err) == codes.Unimplemented {
+		s.lock.Lock()
+		s.unary = true
+		s.lock.Unlock()
+
+		ack, err1 := s.client.Deliver(ctx, evt)
+		return ackError(ack, err1)
+	}
+
+	return err
+}
+
+// openStream returns the working stream, a new one is opened if the plugin is serving.
+func (s *grpcSink) openStream(ctx context.Context) (*grpcStream, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.stream != nil && !s.stream.broken() {
+		return s.stream, nil
+	}
+
+	if err := s.probe(ctx); err != nil {
+		return nil, err
+	}
+
+	streamCtx, cancel := context.WithCancel(context.Background())
+	client, err := s.client.DeliverStream(streamCtx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	s.stream = &grpcStream{client: client, cancel: cancel, pending: map[string]chan error{}}
+	go s.stream.receive()
+
+	return s.stream, nil
+}
+
+// probe checks the readiness of the plugin by the health checking protocol.
+// The plugin is regarded as serving if it does not implement the protocol.
+func (s *grpcSink) probe(ctx context.Context) error {
+	resp, err := s.health.Check(ctx, &healthpb.HealthCheckRequest{})
+	if status.Code(err) == codes.Unimplemented {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return errors.New("the plugin is " + resp.GetStatus().String())
+	}
+
+	return nil
+}
+
+func (s *grpcSink) close() error {
+	s.lock.Lock()
+	if s.stream != nil {
+		s.stream.close()
+		s.stream = nil
+	}
+	s.lock.Unlock()
+
+	return s.conn.Close()
+}
+
+// grpcStream is a DeliverStream call, the acks are matched to the events by id.
+// The sends are serialized by sendLock instead of lock, so that a send blocked by
+// the flow control does not block the receiving of the acks.
+type grpcStream struct {
+	lock     sync.Mutex
+	sendLock sync.Mutex
+	client   deliveryv1.Delivery_DeliverStreamClient
+	cancel   context.CancelFunc
+	pending  map[string]chan error
+	err      error
+}
+
+func (s *grpcStream) deliver(ctx context.Context, evt *deliveryv1.Event) error {
+	done := make(chan error, 1)
+
+	s.lock.Lock()
+	if s.err != nil {
+		s.lock.Unlock()
+		return s.err
+	}
+	s.pending[evt.Id] = done
+	s.lock.Unlock()
+
+	// Send can not be canceled, the stream is broken instead if the deadline is exceeded
+	s.sendLock.Lock()
+	stop := context.AfterFunc(ctx, s.cancel)
+	err := s.client.Send(evt)
+	stop()
+	s.sendLock.Unlock()
+
+	if err != nil {
+		// the real error will be reported by the receiving
+		s.cancel()
+	}
+
+	select {
+	case err = <-done:
+		return err
+	case <-ctx.Done():
+		s.lock.Lock()
+		delete(s.pending, evt.Id)
+		s.lock.Unlock()
+
+		return ctx.Err()
+	}
+}
+
+func (s *grpcStream) receive() {
+	for {
+		ack, err := s.client.Recv()
+
+		s.lock.Lock()
+		if err != nil {
+			s.err = err
+			for id, done := range s.pending {
+				done <- err
+				delete(s.pending, id)
+			}
+			s.lock.Unlock()
+			s.cancel()
+
+			return
+		}
+
+		if done, ok := s.pending[ack.GetId()]; ok {
+			done <- ackError(ack, nil)
+			delete(s.pending, ack.GetId())
+		}
+		s.lock.Unlock()
+	}
+}
+
+func (s *grpcStream) broken() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.err != nil
+}
+
+func (s *grpcStream) close() {
+	s.sendLock.Lock()
+	_ = s.client.CloseSend()
+	s.sendLock.Unlock()
+	s.cancel()
+}
+
+func ackError(ack *deliveryv1.Ack, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if !ack.GetAccepted() {
+		return errors.New("the plugin rejected the event: " + ack.GetReason())
+	}
+
+	return nil
+}
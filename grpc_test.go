@@ -0,0 +1,148 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"errors"
+	deliveryv1 "github.com/opensourceways/robot-universal-access/api/delivery/v1"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// deliveryServer is a plugin which accepts the events unless they are from the org "reject".
+type deliveryServer struct {
+	deliveryv1.UnimplementedDeliveryServer
+
+	lock   sync.Mutex
+	events []*deliveryv1.Event
+}
+
+func (s *deliveryServer) Deliver(_ context.Context, evt *deliveryv1.Event) (*deliveryv1.Ack, error) {
+	s.lock.Lock()
+	s.events = append(s.events, evt)
+	s.lock.Unlock()
+
+	if evt.GetOrg() == "reject" {
+		return &deliveryv1.Ack{Id: evt.GetId(), Reason: "rejected"}, nil
+	}
+	return &deliveryv1.Ack{Id: evt.GetId(), Accepted: true}, nil
+}
+
+// streamDeliveryServer implements the stream as well.
+type streamDeliveryServer struct {
+	deliveryServer
+}
+
+func (s *streamDeliveryServer) DeliverStream(stream deliveryv1.Delivery_DeliverStreamServer) error {
+	for {
+		evt, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		ack, _ := s.Deliver(stream.Context(), evt)
+		if err = stream.Send(ack); err != nil {
+			return err
+		}
+	}
+}
+
+func startDeliveryServer(t *testing.T, srv deliveryv1.DeliveryServer, serving healthpb.HealthCheckResponse_ServingStatus) sink {
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	deliveryv1.RegisterDeliveryServer(server, srv)
+	hs := health.NewServer()
+	hs.SetServingStatus("", serving)
+	healthpb.RegisterHealthServer(server, hs)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	t.Cleanup(server.Stop)
+
+	u, _ := url.Parse("grpc://bufnet")
+	s, err := newGRPCSink(
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+	)(nil, nil, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = s.close()
+	})
+
+	return s
+}
+
+func newTestMessage(id, org string) *message {
+	msg := &message{header: http.Header{}, body: []byte("{}"), id: id, eventType: headerEventTypeValue, org: org, repo: "test1"}
+	msg.header.Set(headerContentType, contentTypeJSON)
+	msg.header.Set(headerRobotEventType, eventComment)
+	return msg
+}
+
+func TestGRPCSinkStream(t *testing.T) {
+	srv := &streamDeliveryServer{}
+	s := startDeliveryServer(t, srv, healthpb.HealthCheckResponse_SERVING)
+
+	assert.Equal(t, nil, s.send(newTestMessage("1", "ibforuorg")))
+	assert.Equal(t, nil, s.send(newTestMessage("2", "ibforuorg")))
+	assert.Equal(t, errors.New("the plugin rejected the event: rejected"), s.send(newTestMessage("3", "reject")))
+	assert.Equal(t, false, s.(*grpcSink).unary)
+
+	assert.Equal(t, 2+retryCount+1, len(srv.events))
+	evt := srv.events[0]
+	assert.Equal(t, "1", evt.GetId())
+	assert.Equal(t, headerEventTypeValue, evt.GetEventType())
+	assert.Equal(t, eventComment, evt.GetCanonicalEventType())
+	assert.Equal(t, "ibforuorg", evt.GetOrg())
+	assert.Equal(t, "test1", evt.GetRepo())
+	assert.Equal(t, contentTypeJSON, evt.GetContentType())
+	assert.Equal(t, eventComment, evt.GetHeaders()[headerRobotEventType])
+	assert.Equal(t, []byte("{}"), evt.GetPayload())
+}
+
+func TestGRPCSinkUnary(t *testing.T) {
+	srv := &deliveryServer{}
+	s := startDeliveryServer(t, srv, healthpb.HealthCheckResponse_SERVING)
+
+	assert.Equal(t, nil, s.send(newTestMessage("1", "ibforuorg")))
+	assert.Equal(t, true, s.(*grpcSink).unary)
+	assert.Equal(t, nil, s.send(newTestMessage("2", "ibforuorg")))
+	assert.Equal(t, 2, len(srv.events))
+}
+
+func TestGRPCSinkNotServing(t *testing.T) {
+	srv := &streamDeliveryServer{}
+	s := startDeliveryServer(t, srv, healthpb.HealthCheckResponse_NOT_SERVING)
+
+	assert.Equal(t, errors.New("the plugin is NOT_SERVING"), s.send(newTestMessage("1", "ibforuorg")))
+	assert.Equal(t, 0, len(srv.events))
+}
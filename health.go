@@ -0,0 +1,419 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/go-resty/resty/v2"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	healthzPath = "/healthz"
+	readyzPath  = "/readyz"
+
+	healthOK     = "ok"
+	healthFailed = "failed"
+
+	defaultHealthInterval           = 10 * time.Second
+	defaultHealthTimeout            = 2 * time.Second
+	defaultHealthUnhealthyThreshold = 3
+	defaultHealthHealthyThreshold   = 2
+)
+
+var (
+	errConfigNotLoaded = errors.New("the configmap is not loaded")
+	errDraining        = errors.New("the deliveries are draining for the shutdown")
+)
+
+// healthCheckConfig probes the health of a plugin in the background. The plugin is
+// unhealthy after the consecutive failures, and healthy again after the consecutive
+// successes.
+type healthCheckConfig struct {
+	// URL of the health check, the plugin is healthy if it responds 2xx, eg "http://plugin:8080/healthz".
+	URL string `json:"url"`
+
+	// Interval between the probes. Defaults to "10s".
+	Interval duration `json:"interval,omitempty"`
+
+	// Timeout of a probe. Defaults to "2s".
+	Timeout duration `json:"timeout,omitempty"`
+
+	// UnhealthyThreshold is the number of the failures marking it unhealthy. Defaults to 3.
+	UnhealthyThreshold int `json:"unhealthy_threshold,omitempty"`
+
+	// HealthyThreshold is the number of the successes marking it healthy. Defaults to 2.
+	HealthyThreshold int `json:"healthy_threshold,omitempty"`
+}
+
+func (c *healthCheckConfig) validate() error {
+	if u, err := url.Parse(c.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("invalid url " + c.URL)
+	}
+
+	if c.Interval < 0 || c.Timeout < 0 {
+		return errors.New("the interval and the timeout can not be negative")
+	}
+
+	if c.UnhealthyThreshold < 0 || c.HealthyThreshold < 0 {
+		return errors.New("the thresholds can not be negative")
+	}
+
+	return nil
+}
+
+func threshold(n, v int) int {
+	if n <= 0 {
+		return v
+	}
+	return n
+}
+
+// pluginHealth is the health of a plugin learned by the probes, it is healthy until
+// the probes fail.
+type pluginHealth struct {
+	name   string
+	cfg    *healthCheckConfig
+	client *http.Client
+
+	lock      sync.Mutex
+	healthy   bool
+	failures  int // the consecutive failures
+	successes int // the consecutive successes
+	lastProbe time.Time
+	lastError string
+	recovered chan struct{} // closed when it is healthy again
+	stopped   bool
+	stop      chan struct{}
+}
+
+func newPluginHealth(name string, cfg *healthCheckConfig) *pluginHealth {
+	h := &pluginHealth{name: name, cfg: cfg, healthy: true, recovered: make(chan struct{}), stop: make(chan struct{})}
+	pluginHealthy.WithLabelValues(name).Set(1)
+
+	return h
+}
+
+func (h *pluginHealth) run() {
+	ticker := time.NewTicker(h.cfg.Interval.or(defaultHealthInterval))
+	defer ticker.Stop()
+
+	for {
+		h.probe()
+
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *pluginHealth) probe() {
+	ctx, cancel := context.WithTimeout(context.Background(), h.cfg.Timeout.or(defaultHealthTimeout))
+	defer cancel()
+
+	h.observe(probeHealthURL(ctx, h.client, h.cfg.URL), time.Now())
+}
+
+// observe counts the result of a probe, and changes the health once a threshold is reached.
+func (h *pluginHealth) observe(err error, now time.Time) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.lastProbe = now
+	if err != nil {
+		healthProbes.WithLabelValues(h.name, healthFailed).Inc()
+		h.lastError = err.Error()
+		h.successes = 0
+		h.failures++
+		if h.healthy && h.failures >= threshold(h.cfg.UnhealthyThreshold, defaultHealthUnhealthyThreshold) {
+			h.healthy = false
+			pluginHealthy.WithLabelValues(h.name).Set(0)
+		}
+		return
+	}
+
+	healthProbes.WithLabelValues(h.name, healthOK).Inc()
+	h.lastError = ""
+	h.failures = 0
+	h.successes++
+	if !h.healthy && h.successes >= threshold(h.cfg.HealthyThreshold, defaultHealthHealthyThreshold) {
+		h.healthy = true
+		pluginHealthy.WithLabelValues(h.name).Set(1)
+		close(h.recovered)
+		h.recovered = make(chan struct{})
+	}
+}
+
+// isHealthy reports whether the plugin is healthy, a nil health is always healthy.
+func (h *pluginHealth) isHealthy() bool {
+	if h == nil {
+		return true
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.healthy
+}
+
+// wait blocks until the plugin is healthy. The held deliveries are not released by
+// the shutdown, they are persisted if the plugin does not recover during the drain.
+func (h *pluginHealth) wait() {
+	if h == nil {
+		return
+	}
+
+	for {
+		h.lock.Lock()
+		healthy, recovered := h.healthy, h.recovered
+		h.lock.Unlock()
+
+		if healthy {
+			return
+		}
+
+		<-recovered
+	}
+}
+
+// close stops the probing.
+func (h *pluginHealth) close() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if !h.stopped {
+		h.stopped = true
+		close(h.stop)
+	}
+}
+
+// pluginStatus is the health of a plugin reported by the admin API.
+type pluginStatus struct {
+	Name                string     `json:"name"`
+	Healthy             bool       `json:"healthy"`
+	Checked             bool       `json:"checked"` // whether it is health checked
+	ConsecutiveFailures int        `json:"consecutiveFailures,omitempty"`
+	LastProbe           *time.Time `json:"lastProbe,omitempty"`
+	LastError           string     `json:"lastError,omitempty"`
+}
+
+func (h *pluginHealth) status() pluginStatus {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	s := pluginStatus{Name: h.name, Healthy: h.healthy, Checked: true, ConsecutiveFailures: h.failures, LastError: h.lastError}
+	if !h.lastProbe.IsZero() {
+		t := h.lastProbe
+		s.LastProbe = &t
+	}
+
+	return s
+}
+
+// newPluginHealths starts probing the plugins with the health checks by the client.
+func newPluginHealths(c *configuration, client *http.Client) map[string]*pluginHealth {
+	m := map[string]*pluginHealth{}
+	if c == nil {
+		return m
+	}
+
+	for i := range c.ConfigItems.Plugins {
+		if p := &c.ConfigItems.Plugins[i]; p.HealthCheck != nil {
+			h := newPluginHealth(p.Name, p.HealthCheck)
+			h.client = client
+			go h.run()
+			m[p.Name] = h
+		}
+	}
+
+	return m
+}
+
+// saturable is implemented by the sinks with a bounded queue.
+type saturable interface {
+	saturated() bool
+}
+
+func (q *queuedSink) saturated() bool {
+	return len(q.queue) >= cap(q.queue)
+}
+
+func (s *subscription) saturated() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.queue)+len(s.inflight) >= s.bufferSize
+}
+
+type healthCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+	Status string         `json:"status"`
+	Checks []*healthCheck `json:"checks,omitempty"`
+}
+
+func newHealthCheck(name string, err error) *healthCheck {
+	if err != nil {
+		return &healthCheck{Name: name, Status: healthFailed, Error: err.Error()}
+	}
+
+	return &healthCheck{Name: name, Status: healthOK}
+}
+
+// healthz reports the process is alive, it never depends on the plugins.
+func (bot *robot) healthz(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, healthResponse{Status: healthOK})
+}
+
+// readyz reports whether the gateway can accept the webhooks. It is not ready while
+// the configmap is not loaded, any queue of the plugins is saturated or it is draining.
+// The health checks of the plugins are probed as well by "/readyz?deep=true".
+func (bot *robot) readyz(w http.ResponseWriter, r *http.Request) {
+	checks := []*healthCheck{
+		newHealthCheck("configmap", bot.checkConfigmap()),
+		newHealthCheck("queues", bot.checkQueues()),
+		newHealthCheck("shutdown", bot.checkDraining()),
+	}
+	if deep, _ := strconv.ParseBool(r.URL.Query().Get("deep")); deep && bot.configmap != nil {
+		checks = append(checks, bot.probePlugins(r.Context())...)
+	}
+
+	resp := healthResponse{Status: healthOK, Checks: checks}
+	status := http.StatusOK
+	for _, c := range checks {
+		if c.Status != healthOK {
+			resp.Status = healthFailed
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	writeJSON(w, status, resp)
+}
+
+func (bot *robot) checkConfigmap() error {
+	if bot.configmap == nil {
+		return errConfigNotLoaded
+	}
+
+	return nil
+}
+
+func (bot *robot) checkQueues() error {
+	bot.sinkLock.Lock()
+	defer bot.sinkLock.Unlock()
+
+	for name, s := range bot.sinks {
+		if q, ok := s.(saturable); ok && q.saturated() {
+			return errors.New("the queue of plugin " + name + " is saturated")
+		}
+	}
+
+	for name, s := range bot.subscriptions.items {
+		if s.saturated() {
+			return errors.New("the subscription of plugin " + name + " is saturated")
+		}
+	}
+
+	return nil
+}
+
+func (bot *robot) checkDraining() error {
+	if bot.draining.Load() {
+		return errDraining
+	}
+
+	return nil
+}
+
+// probePlugins probes the health checks of the plugins in parallel.
+func (bot *robot) probePlugins(ctx context.Context) []*healthCheck {
+	client := newProbeClient(bot.client)
+
+	var checks []*healthCheck
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for i := range bot.configmap.ConfigItems.Plugins {
+		p := &bot.configmap.ConfigItems.Plugins[i]
+		if p.HealthCheck == nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, p.HealthCheck.Timeout.or(defaultHealthTimeout))
+			defer cancel()
+
+			c := newHealthCheck("plugin:"+p.Name, probeHealthURL(ctx, client, p.HealthCheck.URL))
+			lock.Lock()
+			checks = append(checks, c)
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	slices.SortFunc(checks, func(a, b *healthCheck) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return checks
+}
+
+// newProbeClient returns the client of the health probes. It shares the transport of
+// the deliveries, so the plugins are reached as the deliveries reach them, and it
+// does not follow the redirects.
+func newProbeClient(c *resty.Client) *http.Client {
+	return &http.Client{
+		Transport: c.GetClient().Transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func probeHealthURL(ctx context.Context, client *http.Client, uri string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	// the body is drained so that the connection is reused by the next probe
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &statusError{code: resp.StatusCode}
+	}
+
+	return nil
+}
@@ -0,0 +1,220 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func callReadyz(bot *robot, path string) (int, healthResponse) {
+	w := httptest.NewRecorder()
+	bot.readyz(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+	resp := healthResponse{}
+	_ = json.NewDecoder(w.Body).Decode(&resp)
+
+	return w.Code, resp
+}
+
+func TestHealthz(t *testing.T) {
+	bot := newRobot(nil)
+
+	w := httptest.NewRecorder()
+	bot.healthz(w, httptest.NewRequest(http.MethodGet, healthzPath, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"status":"ok"}`+"\n", w.Body.String())
+}
+
+func TestReadyz(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	// it is not ready without the configmap, and the webhooks are rejected
+	bot := newRobot(nil)
+	code, resp := callReadyz(bot, readyzPath)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, healthFailed, resp.Status)
+	assert.Equal(t, &healthCheck{Name: "configmap", Status: healthFailed, Error: errConfigNotLoaded.Error()}, resp.Checks[0])
+
+	w := httptest.NewRecorder()
+	bot.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhook", nil))
+	assertRejected(t, w, configNotLoadedErrorMessage)
+
+	bot = newRobot(&configuration{ConfigItems: accessConfig{
+		Plugins: []pluginConfig{
+			{Name: "serv1", Endpoint: server.URL, HealthCheck: &healthCheckConfig{URL: server.URL + "/up"}},
+			{Name: "serv2", Endpoint: server.URL, HealthCheck: &healthCheckConfig{URL: server.URL + "/down"}},
+			{Name: "serv3", Endpoint: server.URL},
+		},
+	}})
+	code, resp = callReadyz(bot, readyzPath)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, healthResponse{Status: healthOK, Checks: []*healthCheck{
+		{Name: "configmap", Status: healthOK},
+		{Name: "queues", Status: healthOK},
+		{Name: "shutdown", Status: healthOK},
+	}}, resp)
+
+	// the deep check probes the plugins declaring the health URLs
+	code, resp = callReadyz(bot, readyzPath+"?deep=true")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, []*healthCheck{
+		{Name: "plugin:serv1", Status: healthOK},
+		{Name: "plugin:serv2", Status: healthFailed, Error: "the plugin responded with status 503"},
+	}, resp.Checks[3:])
+
+	// the queue of serv1 is saturated
+	q := &queuedSink{name: "serv1", queue: make(chan queuedMessage, 1)}
+	q.queue <- queuedMessage{}
+	bot.sinks["serv1"] = q
+	code, resp = callReadyz(bot, readyzPath)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, &healthCheck{Name: "queues", Status: healthFailed, Error: "the queue of plugin serv1 is saturated"}, resp.Checks[1])
+
+	bot.draining.Store(true)
+	for _, h := range bot.health {
+		h.close()
+	}
+	code, resp = callReadyz(bot, readyzPath)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, &healthCheck{Name: "shutdown", Status: healthFailed, Error: errDraining.Error()}, resp.Checks[2])
+}
+
+func TestPluginHealth(t *testing.T) {
+	h := newPluginHealth("health1", &healthCheckConfig{UnhealthyThreshold: 2})
+	defer h.close()
+
+	errDown := errors.New("down")
+	now := time.Date(2024, 10, 1, 8, 0, 0, 0, time.UTC)
+	h.observe(errDown, now)
+	assert.Equal(t, true, h.isHealthy())
+	h.observe(errDown, now)
+	assert.Equal(t, false, h.isHealthy())
+	assert.Equal(t, 0.0, testutil.ToFloat64(pluginHealthy.WithLabelValues("health1")))
+	assert.Equal(t, pluginStatus{
+		Name: "health1", Checked: true, ConsecutiveFailures: 2, LastProbe: &now, LastError: "down",
+	}, h.status())
+
+	// the deliveries wait until it is healthy by the default threshold
+	released := make(chan struct{})
+	go func() {
+		h.wait()
+		close(released)
+	}()
+	h.observe(nil, now)
+	select {
+	case <-released:
+		t.Fatal("released before it recovers")
+	case <-time.After(20 * time.Millisecond):
+	}
+	h.observe(nil, now)
+	<-released
+	assert.Equal(t, true, h.isHealthy())
+	assert.Equal(t, 1.0, testutil.ToFloat64(pluginHealthy.WithLabelValues("health1")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(healthProbes.WithLabelValues("health1", healthFailed)))
+}
+
+func TestQueuedSinkUnhealthy(t *testing.T) {
+	h := newPluginHealth("health2", &healthCheckConfig{UnhealthyThreshold: 1, HealthyThreshold: 1})
+	h.observe(errors.New("down"), time.Now())
+
+	inner := &recordingSink{}
+	q := newQueuedSink(inner, &pluginConfig{Name: "health2"}, h)
+	assert.Equal(t, errPluginUnhealthy, q.send(&message{id: "0"}))
+
+	done := make(chan error, 1)
+	assert.Equal(t, nil, q.enqueue(&message{id: "1"}, func(err error) { done <- err }))
+	select {
+	case <-done:
+		t.Fatal("sent to an unhealthy plugin")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	h.observe(nil, time.Now())
+	assert.Equal(t, nil, <-done)
+	assert.Equal(t, []string{"1"}, inner.ids)
+
+	// the held messages are not released by the shutdown
+	h.observe(errors.New("down"), time.Now())
+	assert.Equal(t, nil, q.enqueue(&message{id: "2"}, func(err error) { done <- err }))
+	h.close()
+	select {
+	case <-done:
+		t.Fatal("released by the shutdown")
+	case <-time.After(20 * time.Millisecond):
+	}
+	assert.Equal(t, []string{"1"}, inner.ids)
+}
+
+func TestAdminPlugins(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	bot := newRobot(&configuration{ConfigItems: accessConfig{
+		Plugins: []pluginConfig{
+			{Name: "serv1", Endpoint: server.URL, HealthCheck: &healthCheckConfig{
+				URL: server.URL, Interval: duration(10 * time.Millisecond), UnhealthyThreshold: 1,
+			}},
+			{Name: "serv2", Endpoint: server.URL},
+		},
+	}})
+	defer bot.wait()
+	assert.Eventually(t, func() bool { return !bot.health["serv1"].isHealthy() }, time.Second, 5*time.Millisecond)
+
+	w := httptest.NewRecorder()
+	bot.admin(w, httptest.NewRequest(http.MethodGet, adminPathPrefix+"plugins", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var statuses []pluginStatus
+	assert.Equal(t, nil, json.NewDecoder(w.Body).Decode(&statuses))
+	assert.Equal(t, 2, len(statuses))
+	assert.Equal(t, "serv1", statuses[0].Name)
+	assert.Equal(t, false, statuses[0].Healthy)
+	assert.Equal(t, true, statuses[0].Checked)
+	assert.Equal(t, "the plugin responded with status 503", statuses[0].LastError)
+	assert.Equal(t, pluginStatus{Name: "serv2", Healthy: true}, statuses[1])
+
+	w = httptest.NewRecorder()
+	bot.admin(w, httptest.NewRequest(http.MethodGet, adminPathPrefix+"unknown", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestProbeHealthURL(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+	redirected := httptest.NewServer(http.RedirectHandler(healthy.URL, http.StatusFound))
+	defer redirected.Close()
+
+	// the probes reach the plugins as the deliveries do, not by the proxy
+	bot := newRobot(&configuration{})
+	client := newProbeClient(bot.client)
+	assert.Equal(t, true, client.Transport.(*http.Transport).Proxy == nil)
+
+	assert.Equal(t, nil, probeHealthURL(context.Background(), client, healthy.URL))
+	assert.Equal(t, &statusError{code: http.StatusFound}, probeHealthURL(context.Background(), client, redirected.URL))
+}
@@ -0,0 +1,393 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/opensourceways/server-common-lib/utils"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	lintCommand = "lint"
+
+	outputText = "text"
+	outputJSON = "json"
+
+	severityError   = "error"
+	severityWarning = "warning"
+
+	// the codes of the lint problems
+	lintInvalidYAML     = "invalid-yaml"
+	lintInvalidConfig   = "invalid-config"
+	lintInvalidPlugin   = "invalid-plugin"
+	lintInvalidURL      = "invalid-url"
+	lintDuplicatePlugin = "duplicate-plugin"
+	lintUnknownPlugin   = "unknown-plugin"
+	lintUnusedPlugin    = "unused-plugin"
+	lintUnknownEvent    = "unknown-event"
+	lintUnreachable     = "unreachable-binding"
+	lintDuplicateBind   = "duplicate-binding"
+	lintShadowedBinding = "shadowed-binding"
+	lintShadowedRoute   = "shadowed-sync-route"
+)
+
+// lintProblem is a problem of the configmap found by the linter. The path is the
+// location of the field, eg "access.plugins[0].endpoint".
+type lintProblem struct {
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Path     string `json:"path"`
+	Message  string `json:"message"`
+}
+
+type lintReport struct {
+	Problems []*lintProblem `json:"problems"`
+	Errors   int            `json:"errors"`
+	Warnings int            `json:"warnings"`
+}
+
+// runLint lints the configmap files, it exits with 1 if there is any error, or 2 if
+// the arguments are invalid:
+//
+//	robot-universal-access lint [-format text|json] FILE...
+func runLint(args []string, stdout io.Writer) int {
+	fs := flag.NewFlagSet(lintCommand, flag.ContinueOnError)
+	format := fs.String("format", outputText, "the output format, text or json")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(fs.Output(), "usage: "+lintCommand+" [-format text|json] FILE...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 || (*format != outputText && *format != outputJSON) {
+		fs.Usage()
+		return 2
+	}
+
+	report := lintReport{Problems: []*lintProblem{}}
+	for _, file := range fs.Args() {
+		report.Problems = append(report.Problems, lintFile(file)...)
+	}
+	for _, p := range report.Problems {
+		if p.Severity == severityError {
+			report.Errors++
+		} else {
+			report.Warnings++
+		}
+	}
+
+	if *format == outputJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(report)
+	} else {
+		for _, p := range report.Problems {
+			_, _ = fmt.Fprintln(stdout, p.String())
+		}
+		_, _ = fmt.Fprintf(stdout, "%d error(s), %d warning(s)\n", report.Errors, report.Warnings)
+	}
+
+	if report.Errors > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+func (p *lintProblem) String() string {
+	pos := p.File
+	if p.Line > 0 {
+		pos += ":" + strconv.Itoa(p.Line) + ":" + strconv.Itoa(p.Column)
+	}
+
+	return pos + ": " + p.Severity + ": " + p.Message + " (" + p.Code + ")"
+}
+
+// lintFile lints a configmap file, the problems are sorted by their positions.
+func lintFile(file string) []*lintProblem {
+	cfg := &configuration{}
+	if err := utils.LoadFromYaml(file, cfg); err != nil {
+		return []*lintProblem{{File: file, Severity: severityError, Code: lintInvalidYAML, Message: err.Error()}}
+	}
+
+	// the positions are best effort, the problems are reported without them if it fails
+	positions := map[string]*yaml.Node{}
+	if data, err := os.ReadFile(file); err == nil {
+		root := yaml.Node{}
+		if yaml.Unmarshal(data, &root) == nil {
+			indexPositions(&root, "", positions)
+		}
+	}
+
+	problems := lintConfig(&cfg.ConfigItems)
+	for _, p := range problems {
+		p.File = file
+		if n := positionOf(positions, p.Path); n != nil {
+			p.Line, p.Column = n.Line, n.Column
+		}
+	}
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].Line != problems[j].Line {
+			return problems[i].Line < problems[j].Line
+		}
+		return problems[i].Column < problems[j].Column
+	})
+
+	return problems
+}
+
+// indexPositions maps the paths of the fields to their yaml nodes, the key nodes are
+// used for the fields of the mappings.
+func indexPositions(n *yaml.Node, path string, m map[string]*yaml.Node) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, c := range n.Content {
+			indexPositions(c, path, m)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			p := n.Content[i].Value
+			if path != "" {
+				p = path + "." + p
+			}
+			m[p] = n.Content[i]
+			indexPositions(n.Content[i+1], p, m)
+		}
+	case yaml.SequenceNode:
+		for i, c := range n.Content {
+			p := path + "[" + strconv.Itoa(i) + "]"
+			m[p] = c
+			indexPositions(c, p, m)
+		}
+	}
+}
+
+// positionOf returns the node of the path, or of its nearest parent found.
+func positionOf(m map[string]*yaml.Node, path string) *yaml.Node {
+	for path != "" {
+		if n, ok := m[path]; ok {
+			return n
+		}
+
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			return nil
+		}
+		path = path[:i]
+	}
+
+	return nil
+}
+
+type linter struct {
+	cfg      *accessConfig
+	problems []*lintProblem
+}
+
+// lintConfig reports all the problems of the configuration, the positions are not set.
+func lintConfig(a *accessConfig) []*lintProblem {
+	l := &linter{cfg: a}
+	l.lintGlobal()
+	l.lintPlugins()
+	l.lintBindings()
+	l.lintSyncRoutes()
+
+	return l.problems
+}
+
+func (l *linter) report(severity, code, path, message string) {
+	l.problems = append(l.problems, &lintProblem{Severity: severity, Code: code, Path: path, Message: message})
+}
+
+func (l *linter) lintGlobal() {
+	if err := l.cfg.RateLimits.validate(); err != nil {
+		l.report(severityError, lintInvalidConfig, "access.rate_limits", err.Error())
+	}
+
+	for i := range l.cfg.Redact {
+		if err := l.cfg.Redact[i].validate(); err != nil {
+			l.report(severityError, lintInvalidConfig, "access.redact["+strconv.Itoa(i)+"]", err.Error())
+		}
+	}
+
+	if err := l.cfg.Shutdown.validate(); err != nil {
+		l.report(severityError, lintInvalidConfig, "access.shutdown", err.Error())
+	}
+}
+
+func (l *linter) lintPlugins() {
+	defined := map[string]int{}
+	for i := range l.cfg.Plugins {
+		p := &l.cfg.Plugins[i]
+		path := "access.plugins[" + strconv.Itoa(i) + "]"
+
+		if first, ok := defined[p.Name]; ok && p.Name != "" {
+			l.report(severityError, lintDuplicatePlugin, path+".name",
+				"plugin "+p.Name+" is already defined by access.plugins["+strconv.Itoa(first)+"]")
+		} else {
+			defined[p.Name] = i
+		}
+
+		for _, problem := range p.problems() {
+			code := lintInvalidPlugin
+			if problem.url {
+				code = lintInvalidURL
+			}
+			l.report(severityError, code, path+"."+problem.field, problem.err.Error())
+		}
+
+		l.lintEvents(p.Events, path+".events")
+		if p.Debounce != nil {
+			l.lintEvents(p.Debounce.Events, path+".debounce.events")
+		}
+
+		if p.Name != "" && !l.isBound(p.Name) {
+			l.report(severityWarning, lintUnusedPlugin, path+".name",
+				"plugin "+p.Name+" is not bound to any org or repository in access.repo_plugins")
+		}
+	}
+}
+
+func (l *linter) lintEvents(events []string, path string) {
+	for i, e := range events {
+		if !knownEventType(e) {
+			l.report(severityWarning, lintUnknownEvent, path+"["+strconv.Itoa(i)+"]",
+				"event "+strconv.Quote(e)+" is neither a canonical event nor a known raw event of a platform")
+		}
+	}
+}
+
+func (l *linter) isDefined(name string) bool {
+	return slices.ContainsFunc(l.cfg.Plugins, func(p pluginConfig) bool { return p.Name == name })
+}
+
+func (l *linter) isBound(name string) bool {
+	for _, names := range l.cfg.RepoPlugins {
+		if slices.Contains(names, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isRepoKey reports whether the key is an org (eg "ibforuorg") or a repository
+// (eg "ibforuorg/test1"), any other key never matches a webhook.
+func isRepoKey(key string) bool {
+	org, repo, found := strings.Cut(key, "/")
+
+	return org != "" && strings.TrimSpace(key) == key && (!found || (repo != "" && !strings.Contains(repo, "/")))
+}
+
+func (l *linter) lintBindings() {
+	keys := make([]string, 0, len(l.cfg.RepoPlugins))
+	for key := range l.cfg.RepoPlugins {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		path := "access.repo_plugins." + key
+		if !isRepoKey(key) {
+			l.report(severityError, lintUnreachable, path,
+				"binding "+strconv.Quote(key)+" matches no webhook, it must be an org or an org/repo")
+		}
+
+		org, _, isRepo := strings.Cut(key, "/")
+		names := l.cfg.RepoPlugins[key]
+		for i, name := range names {
+			item := path + "[" + strconv.Itoa(i) + "]"
+			switch {
+			case !l.isDefined(name):
+				l.report(severityError, lintUnknownPlugin, item, "plugin "+name+" is not defined in access.plugins")
+			case slices.Index(names, name) < i:
+				l.report(severityWarning, lintDuplicateBind, item, "plugin "+name+" is bound to "+key+" more than once")
+			case isRepo && slices.Contains(l.cfg.RepoPlugins[org], name):
+				// the bindings of the org and the repository are both matched
+				l.report(severityWarning, lintShadowedBinding, item,
+					"plugin "+name+" is bound to the org "+org+" too, it receives the webhooks of "+key+" twice")
+			}
+		}
+	}
+}
+
+func (l *linter) lintSyncRoutes() {
+	for i := range l.cfg.SyncRoutes {
+		r := &l.cfg.SyncRoutes[i]
+		path := "access.sync_routes[" + strconv.Itoa(i) + "]"
+
+		if err := r.validate(); err != nil {
+			l.report(severityError, lintInvalidConfig, path, err.Error())
+		}
+
+		l.lintEvents(r.Events, path+".events")
+
+		for j, name := range r.Required {
+			if !l.isDefined(name) {
+				l.report(severityError, lintUnknownPlugin, path+".required["+strconv.Itoa(j)+"]",
+					"plugin "+name+" is not defined in access.plugins")
+			}
+		}
+
+		// the first matching route is used
+		for j := 0; j < i; j++ {
+			if l.cfg.SyncRoutes[j].covers(r) {
+				l.report(severityWarning, lintShadowedRoute, path,
+					"the sync route is never used, every webhook of it is matched by access.sync_routes["+strconv.Itoa(j)+"] first")
+				break
+			}
+		}
+	}
+}
+
+// covers reports whether every webhook matching the other route matches this one.
+func (r *syncRoute) covers(other *syncRoute) bool {
+	if len(r.Repos) > 0 {
+		if len(other.Repos) == 0 {
+			return false
+		}
+		for _, repo := range other.Repos {
+			org, _, _ := strings.Cut(repo, "/")
+			if !slices.Contains(r.Repos, repo) && !slices.Contains(r.Repos, org) {
+				return false
+			}
+		}
+	}
+
+	if len(r.Events) > 0 {
+		if len(other.Events) == 0 {
+			return false
+		}
+		for _, e := range other.Events {
+			if !eventMatches(r.Events, e) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
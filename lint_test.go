@@ -0,0 +1,110 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestLintFile(t *testing.T) {
+	type problem struct {
+		line     int
+		severity string
+		code     string
+		path     string
+	}
+	want := []problem{
+		{5, severityWarning, lintDuplicateBind, "access.repo_plugins.ibforuorg[1]"},
+		{7, severityWarning, lintShadowedBinding, "access.repo_plugins.ibforuorg/test1[0]"},
+		{8, severityError, lintUnknownPlugin, "access.repo_plugins.ibforuorg/test1[1]"},
+		{9, severityError, lintUnreachable, "access.repo_plugins.ibforuorg/test1/extra"},
+		{16, severityWarning, lintUnknownEvent, "access.plugins[0].events[0]"},
+		{18, severityError, lintInvalidURL, "access.plugins[1].endpoint"},
+		{19, severityError, lintDuplicatePlugin, "access.plugins[2].name"},
+		{21, severityWarning, lintUnusedPlugin, "access.plugins[3].name"},
+		// every invalid field of the plugin is reported
+		{23, severityError, lintInvalidPlugin, "access.plugins[3].ordering"},
+		{24, severityError, lintInvalidPlugin, "access.plugins[3].format"},
+		{29, severityWarning, lintShadowedRoute, "access.sync_routes[1]"},
+		{32, severityError, lintUnknownPlugin, "access.sync_routes[1].required[0]"},
+	}
+
+	var got []problem
+	for _, p := range lintFile(findTestdata(t, "lint.yaml")) {
+		got = append(got, problem{p.Line, p.Severity, p.Code, p.Path})
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestLintEvents(t *testing.T) {
+	testCases := []struct {
+		no    string
+		event string
+		out   int
+	}{
+		{"case0", eventComment, 0},
+		{"case1", "Note Hook", 0},
+		{"case2", "pull_request_review_comment", 0},
+		{"case3", githubCreateEvent, 0},
+		{"case4", "note", 1},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			l := &linter{cfg: &accessConfig{}}
+			l.lintEvents([]string{testCases[i].event}, "access.plugins[0].events")
+			assert.Equal(t, testCases[i].out, len(l.problems))
+		})
+	}
+}
+
+func TestRunLint(t *testing.T) {
+	testCases := []struct {
+		no       string
+		args     []string
+		code     int
+		problems int
+	}{
+		{"case0", []string{"-format", outputJSON, findTestdata(t, "config.yaml")}, 0, 0},
+		{"case1", []string{"-format", outputJSON, findTestdata(t, "lint.yaml")}, 1, 12},
+		{"case2", []string{"-format", outputJSON, findTestdata(t, "config.yaml"), findTestdata(t, "config23.yaml")}, 1, 1},
+		{"case3", []string{"-format", outputJSON, "testdata/missing.yaml"}, 1, 1},
+		{"case4", []string{}, 2, -1},
+		{"case5", []string{"-format", "xml", findTestdata(t, "config.yaml")}, 2, -1},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			out := bytes.Buffer{}
+			assert.Equal(t, testCases[i].code, runLint(testCases[i].args, &out))
+			if testCases[i].problems < 0 {
+				return
+			}
+
+			report := lintReport{}
+			assert.Equal(t, nil, json.Unmarshal(out.Bytes(), &report))
+			assert.Equal(t, testCases[i].problems, len(report.Problems))
+		})
+	}
+}
+
+func TestRunLintText(t *testing.T) {
+	out := bytes.Buffer{}
+	path := findTestdata(t, "config23.yaml")
+	assert.Equal(t, 1, runLint([]string{path}, &out))
+	assert.Equal(t, path+":8:7: error: serv1 plugin has an invalid discovery: unknown resolver consul (invalid-plugin)\n"+
+		"1 error(s), 0 warning(s)", strings.TrimSpace(out.String()))
+}
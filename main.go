@@ -15,8 +15,9 @@ package main
 
 import (
 	"flag"
-	"github.com/opensourceways/robot-framework-lib/framework"
 	"github.com/opensourceways/server-common-lib/interrupts"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"io"
 	"net/http"
 	"os"
 	"strconv"
@@ -24,7 +25,21 @@ import (
 
 const component = "robot-universal-access"
 
+// subcommands are run by "robot-universal-access {command} [flags] [args]" instead of
+// starting the gateway, they return the exit code.
+var subcommands = map[string]func(args []string, stdout io.Writer) int{
+	lintCommand:     runLint,
+	simulateCommand: runSimulate,
+	diffCommand:     runDiff,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(run(os.Args[2:], os.Stdout))
+		}
+	}
+
 	opt := new(robotOptions)
 	cfg := opt.gatherOptions(flag.NewFlagSet(os.Args[0], flag.ExitOnError), os.Args[1:]...)
 	if opt.interrupt {
@@ -32,20 +47,37 @@ func main() {
 	}
 
 	bot := newRobot(cfg)
-	interrupts.OnInterrupt(func() {
-		bot.wait()
-	})
+	// the deliveries persisted by the last shutdown
+	bot.recoverDeliveries()
 
-	// Return 200 on / for health checks.
+	// Return 200 on / for the legacy health checks.
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/" {
-			bot.log.Error("not a excepted url: " + r.URL.Path)
-			w.WriteHeader(http.StatusBadRequest)
+			http.NotFound(w, r)
 		}
 	})
+	// For the liveness and readiness probes.
+	http.HandleFunc(healthzPath, bot.healthz)
+	http.HandleFunc(readyzPath, bot.readyz)
 	// For /**-hook, handle a webhook normally.
 	http.Handle("/"+opt.service.HandlePath, bot)
+	// For the plugins in the pull mode.
+	http.Handle(subscriptionPathPrefix, bot.subscriptions)
 	httpServer := &http.Server{Addr: ":" + strconv.Itoa(opt.service.Port)}
 
-	framework.StartupServer(httpServer, opt.service)
+	// The admin API of the operators and the prometheus metrics are served on
+	// their own port, which is not exposed with the webhooks.
+	adminMux := http.NewServeMux()
+	adminMux.HandleFunc(adminPathPrefix, bot.admin)
+	adminMux.Handle(metricsPath, promhttp.Handler())
+	adminServer := &http.Server{Addr: ":" + strconv.Itoa(opt.adminPort), Handler: adminMux}
+
+	// the robot is shut down before the server instead of concurrently with it
+	server := &drainingServer{Server: httpServer, bot: bot}
+	if opt.service.EnableTLS {
+		server.certFile, server.keyFile = opt.service.CertFile, opt.service.KeyFile
+	}
+	defer interrupts.WaitForGracefulShutdown()
+	interrupts.ListenAndServe(server, opt.service.GracePeriod)
+	interrupts.ListenAndServe(adminServer, opt.service.GracePeriod)
 }
@@ -0,0 +1,78 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "robot_universal_access"
+	metricsPath      = "/metrics"
+
+	rejectBodyTooLarge = "body_too_large"
+
+	// unknownOrg labels the orgs not configured
+	unknownOrg = "unknown"
+)
+
+var (
+	// rejectedRequests counts the webhooks rejected by the gateway, the org is
+	// empty if the webhook is rejected before its body is parsed, and "unknown" if
+	// it is not configured.
+	rejectedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rejected_requests_total",
+		Help:      "The number of webhooks rejected by the gateway.",
+	}, []string{"reason", "org"})
+
+	// throttledRequests counts the webhooks rejected or delayed by the rate limits.
+	throttledRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "throttled_requests_total",
+		Help:      "The number of webhooks rejected or delayed by the inbound rate limits.",
+	}, []string{"scope", "action"})
+
+	// queueDepth is the number of the messages waiting for the rate limit or the recovery of a plugin.
+	queueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "plugin_queue_depth",
+		Help:      "The number of the events waiting in the queue of a rate limited or health checked plugin.",
+	}, []string{"plugin"})
+
+	// endpointEjections counts the endpoints of the plugins ejected as the outliers.
+	endpointEjections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "endpoint_ejections_total",
+		Help:      "The number of the ejections of the plugin endpoints failing the deliveries.",
+	}, []string{"plugin", "endpoint"})
+
+	// pluginHealthy is 1 if a health checked plugin is healthy, otherwise 0.
+	pluginHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "plugin_healthy",
+		Help:      "Whether a health checked plugin is healthy.",
+	}, []string{"plugin"})
+
+	// healthProbes counts the health probes of the plugins by the results, "ok" or "failed".
+	healthProbes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "plugin_health_probes_total",
+		Help:      "The number of the health probes of the plugins.",
+	}, []string{"plugin", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(rejectedRequests, throttledRequests, queueDepth, pluginHealthy, healthProbes, endpointEjections)
+}
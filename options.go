@@ -19,14 +19,21 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+const defaultAdminPort = 9090
+
 type robotOptions struct {
 	service   config.FrameworkOptions
 	interrupt bool
+
+	// adminPort serves the admin API and the metrics apart from the webhooks,
+	// it must not be exposed publicly
+	adminPort int
 }
 
 func (o *robotOptions) gatherOptions(fs *flag.FlagSet, args ...string) *configuration {
 
 	o.service.AddFlagsComposite(fs)
+	fs.IntVar(&o.adminPort, "admin-port", defaultAdminPort, "Port to serve the admin API and the metrics on, apart from the webhooks.")
 
 	_ = fs.Parse(args)
 
@@ -35,6 +42,11 @@ func (o *robotOptions) gatherOptions(fs *flag.FlagSet, args ...string) *configur
 		o.interrupt = true
 		return nil
 	}
+	if o.adminPort == o.service.Port {
+		logrus.Error("invalid service startup arguments: the admin port must differ from the port of the webhooks")
+		o.interrupt = true
+		return nil
+	}
 	configmap, err := config.NewConfigmapAgent(&configuration{}, o.service.ConfigFile)
 	if err != nil {
 		logrus.WithError(err).Error("invalid item exists in the configmap")
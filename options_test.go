@@ -41,6 +41,7 @@ func TestGatherOptions(t *testing.T) {
 	assert.Equal(t, false, opt.interrupt)
 	assert.Equal(t, "webhook", opt.service.HandlePath)
 	assert.Equal(t, 8511, opt.service.Port)
+	assert.Equal(t, defaultAdminPort, opt.adminPort)
 
 	args = []string{
 		commandExecFile,
@@ -79,3 +80,17 @@ func TestGatherOptions(t *testing.T) {
 	_ = utils.LoadFromYaml(findTestdata(t, configYaml), want)
 	assert.Equal(t, *want, *got)
 }
+
+func TestGatherOptionsAdminPort(t *testing.T) {
+	config := commandConfigFilePrefix + findTestdata(t, configYaml)
+
+	opt := new(robotOptions)
+	_ = opt.gatherOptions(flag.NewFlagSet(commandExecFile, flag.ExitOnError), commandPort, "--admin-port=9091", config)
+	assert.Equal(t, false, opt.interrupt)
+	assert.Equal(t, 9091, opt.adminPort)
+
+	// the admin API must not be served with the webhooks
+	opt = new(robotOptions)
+	_ = opt.gatherOptions(flag.NewFlagSet(commandExecFile, flag.ExitOnError), commandPort, "--admin-port=8511", config)
+	assert.Equal(t, true, opt.interrupt)
+}
@@ -0,0 +1,105 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"github.com/opensourceways/robot-framework-lib/utils"
+	"sync"
+)
+
+const (
+	// orderingRepo delivers the events of a repository one at a time.
+	orderingRepo = "repo"
+	// orderingNumber delivers the events of a pull request or an issue one at a time,
+	// the events without a number are ordered by the repository.
+	orderingNumber = "number"
+)
+
+// orderingKey returns the key of the events which must be delivered in order.
+func (d *delivery) orderingKey(ordering string) string {
+	key := utils.GetString(d.event.Org) + "/" + utils.GetString(d.event.Repo)
+	if ordering == orderingNumber {
+		if n := utils.GetString(d.event.Number); n != "" {
+			key += "#" + n
+		}
+	}
+
+	return key
+}
+
+// sequencer hands out the tickets of the keys in the arrival order of the events.
+type sequencer struct {
+	lock  sync.Mutex
+	tails map[string]*ticket
+}
+
+func newSequencer() *sequencer {
+	return &sequencer{tails: map[string]*ticket{}}
+}
+
+// ticket is the turn of an event, the events of the same key are chained.
+type ticket struct {
+	seq  *sequencer
+	key  string
+	prev chan struct{} // closed when the previous event is done
+	done chan struct{}
+}
+
+// take returns the ticket after the last one of the key, it must be released.
+func (s *sequencer) take(key string) *ticket {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	t := &ticket{seq: s, key: key, done: make(chan struct{})}
+	if tail, ok := s.tails[key]; ok {
+		t.prev = tail.done
+	}
+	s.tails[key] = t
+
+	return t
+}
+
+// wait blocks until the previous events of the key are done.
+func (t *ticket) wait() {
+	if t != nil && t.prev != nil {
+		<-t.prev
+	}
+}
+
+// release passes the turn to the next event of the key, it must be called after wait.
+func (t *ticket) release() {
+	if t == nil {
+		return
+	}
+
+	t.seq.lock.Lock()
+	defer t.seq.lock.Unlock()
+
+	close(t.done)
+	if t.seq.tails[t.key] == t {
+		delete(t.seq.tails, t.key)
+	}
+}
+
+// skip releases the ticket of an event which will not be delivered, once it is its turn.
+func (t *ticket) skip() {
+	if t == nil {
+		return
+	}
+
+	go func() {
+		t.wait()
+		t.release()
+	}()
+}
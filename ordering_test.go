@@ -0,0 +1,106 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOrderingKey(t *testing.T) {
+	d := newTestDelivery()
+	assert.Equal(t, "ibforuorg/test1", d.orderingKey(orderingRepo))
+	assert.Equal(t, "ibforuorg/test1", d.orderingKey(orderingNumber))
+
+	number := "4"
+	d.event.Number = &number
+	assert.Equal(t, "ibforuorg/test1", d.orderingKey(orderingRepo))
+	assert.Equal(t, "ibforuorg/test1#4", d.orderingKey(orderingNumber))
+}
+
+func TestSequencer(t *testing.T) {
+	seq := newSequencer()
+	a1, a2, a3 := seq.take("a"), seq.take("a"), seq.take("a")
+	b1 := seq.take("b")
+
+	isDone := func(t *ticket) bool {
+		select {
+		case <-t.prev:
+			return true
+		default:
+			return false
+		}
+	}
+
+	// the first ones of the keys do not wait
+	a1.wait()
+	b1.wait()
+	assert.Equal(t, false, isDone(a2))
+
+	a1.release()
+	assert.Equal(t, true, isDone(a2))
+	assert.Equal(t, false, isDone(a3))
+
+	// the skipped ticket passes the turn once it is its turn
+	a2.skip()
+	assert.Eventually(t, func() bool { return isDone(a3) }, time.Second, time.Millisecond)
+
+	a3.release()
+	b1.release()
+	assert.Equal(t, 0, len(seq.tails))
+}
+
+func TestDispatchOrdered(t *testing.T) {
+	lock := sync.Mutex{}
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(headerEventGUID)
+		if id == "1" {
+			// the first event is slow, the later ones must wait for it
+			time.Sleep(100 * time.Millisecond)
+		}
+		lock.Lock()
+		received = append(received, id)
+		lock.Unlock()
+	}))
+	defer server.Close()
+
+	bot := newRobot(&configuration{ConfigItems: accessConfig{
+		RepoPlugins: map[string][]string{"ibforuorg": {"serv1"}},
+		Plugins: []pluginConfig{{
+			Name: "serv1", Endpoint: server.URL, Events: []string{eventComment}, Ordering: orderingNumber,
+		}},
+	}})
+
+	data, _ := os.ReadFile(findTestdata(t, "pr_note.json"))
+	for _, id := range []string{"1", "2", "3"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/ordered", bytes.NewReader(data))
+		req.Header.Set(headerContentTypeName, headerContentTypeJsonValue)
+		req.Header.Set(headerEventType, headerEventTypeValue)
+		req.Header.Set(headerEventGUID, id)
+		bot.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	}
+	bot.wait()
+
+	assert.Equal(t, []string{"1", "2", "3"}, received)
+	assert.Equal(t, 0, len(bot.sequencer.tails))
+}
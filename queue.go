@@ -0,0 +1,138 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/time/rate"
+	"sync"
+)
+
+const defaultQueueSize = 1000
+
+var (
+	errQueueFull       = errors.New("the queue of the plugin is full")
+	errQueueClosed     = errors.New("the queue of the plugin is closed")
+	errPluginUnhealthy = errors.New("the plugin is unhealthy")
+)
+
+// asyncSink accepts the messages which are sent later, done is called with the
+// result of the sending.
+type asyncSink interface {
+	sink
+	enqueue(msg *message, done func(error)) error
+}
+
+type queuedMessage struct {
+	msg  *message
+	done func(error)
+}
+
+// queuedSink smooths the deliveries to a plugin by its rate limit, and holds them
+// while the plugin is unhealthy. The messages wait in the queue, and are sent one
+// by one by a worker.
+type queuedSink struct {
+	sink
+	name    string
+	limiter *rate.Limiter
+	health  *pluginHealth // nil if the plugin is not health checked
+
+	lock    sync.Mutex
+	closed  bool
+	queue   chan queuedMessage
+	stopped chan struct{}
+}
+
+func newQueuedSink(s sink, p *pluginConfig, health *pluginHealth) *queuedSink {
+	size := p.QueueSize
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+
+	q := &queuedSink{
+		sink:    s,
+		name:    p.Name,
+		limiter: newLimiter(p.RateLimit),
+		health:  health,
+		queue:   make(chan queuedMessage, size),
+		stopped: make(chan struct{}),
+	}
+	go q.run()
+
+	return q
+}
+
+func (q *queuedSink) enqueue(msg *message, done func(error)) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed {
+		return errQueueClosed
+	}
+
+	select {
+	case q.queue <- queuedMessage{msg: msg, done: done}:
+		queueDepth.WithLabelValues(q.name).Inc()
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// send delivers the message at once bypassing the queue, but it still waits for the rate limit.
+func (q *queuedSink) send(msg *message) error {
+	if !q.health.isHealthy() {
+		return errPluginUnhealthy
+	}
+	_ = q.limiter.Wait(context.Background())
+
+	return q.sink.send(msg)
+}
+
+func (q *queuedSink) run() {
+	defer close(q.stopped)
+
+	for item := range q.queue {
+		q.health.wait()
+		// the limiter never fails without a deadline
+		_ = q.limiter.Wait(context.Background())
+		queueDepth.WithLabelValues(q.name).Dec()
+
+		item.done(q.sink.send(item.msg))
+	}
+}
+
+// close sends the messages left in the queue, then closes the plugin's sink.
+func (q *queuedSink) close() error {
+	q.lock.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.queue)
+	}
+	q.lock.Unlock()
+
+	<-q.stopped
+
+	return q.sink.close()
+}
+
+// newLimiter returns the limiter of the rate limit, it allows any rate if there is no limit.
+func newLimiter(r *rateLimit) *rate.Limiter {
+	if r == nil {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+
+	return rate.NewLimiter(rate.Limit(r.Rate), r.burst())
+}
@@ -0,0 +1,129 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSink records the messages, it blocks the sending until release is closed.
+type recordingSink struct {
+	lock    sync.Mutex
+	ids     []string
+	times   []time.Time
+	release chan struct{}
+	closed  bool
+}
+
+func (s *recordingSink) send(msg *message) error {
+	if s.release != nil {
+		<-s.release
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.ids = append(s.ids, msg.id)
+	s.times = append(s.times, time.Now())
+
+	return nil
+}
+
+func (s *recordingSink) close() error {
+	s.closed = true
+	return nil
+}
+
+func TestQueuedSink(t *testing.T) {
+	inner := &recordingSink{}
+	q := newQueuedSink(inner, &pluginConfig{Name: "queued1", RateLimit: &rateLimit{Rate: 20, Burst: 1}}, nil)
+
+	wg := sync.WaitGroup{}
+	ids := []string{"1", "2", "3", "4", "5"}
+	for _, id := range ids {
+		wg.Add(1)
+		err := q.enqueue(&message{id: id, header: http.Header{}}, func(err error) {
+			assert.Equal(t, nil, err)
+			wg.Done()
+		})
+		assert.Equal(t, nil, err)
+	}
+	wg.Wait()
+
+	assert.Equal(t, ids, inner.ids)
+	// the 4 intervals take 200ms at 20/s, the single ones are not exact enough to be asserted
+	elapsed := inner.times[len(inner.times)-1].Sub(inner.times[0])
+	assert.GreaterOrEqual(t, elapsed, 150*time.Millisecond)
+	assert.Less(t, elapsed, 2*time.Second)
+	assert.Equal(t, 0.0, testutil.ToFloat64(queueDepth.WithLabelValues("queued1")))
+
+	assert.Equal(t, nil, q.close())
+	assert.Equal(t, true, inner.closed)
+	assert.Equal(t, errQueueClosed, q.enqueue(&message{id: "6"}, func(error) {}))
+}
+
+func TestQueuedSinkFull(t *testing.T) {
+	inner := &recordingSink{release: make(chan struct{})}
+	q := newQueuedSink(inner, &pluginConfig{Name: "queued2", RateLimit: &rateLimit{Rate: 100}, QueueSize: 1}, nil)
+
+	// the first one is taken by the worker, and the second one waits in the queue
+	assert.Equal(t, nil, q.enqueue(&message{id: "1"}, func(error) {}))
+	assert.Eventually(t, func() bool { return len(q.queue) == 0 }, time.Second, time.Millisecond)
+	assert.Equal(t, nil, q.enqueue(&message{id: "2"}, func(error) {}))
+	assert.Equal(t, 1.0, testutil.ToFloat64(queueDepth.WithLabelValues("queued2")))
+	assert.Equal(t, errQueueFull, q.enqueue(&message{id: "3"}, func(error) {}))
+
+	// the messages left are sent before the sink is closed
+	close(inner.release)
+	assert.Equal(t, nil, q.close())
+	assert.Equal(t, []string{"1", "2"}, inner.ids)
+	assert.Equal(t, 0.0, testutil.ToFloat64(queueDepth.WithLabelValues("queued2")))
+}
+
+func TestDispatchRateLimited(t *testing.T) {
+	received := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(headerEventGUID)
+	}))
+	defer server.Close()
+
+	bot := newRobot(&configuration{ConfigItems: accessConfig{
+		RepoPlugins: map[string][]string{"ibforuorg": {"serv1"}},
+		Plugins: []pluginConfig{{
+			Name: "serv1", Endpoint: server.URL, Events: []string{eventComment}, RateLimit: &rateLimit{Rate: 50, Burst: 1},
+		}},
+	}})
+
+	data, _ := os.ReadFile(findTestdata(t, "pr_note.json"))
+	for _, id := range []string{"1", "2", "3"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/queued", bytes.NewReader(data))
+		req.Header.Set(headerContentTypeName, headerContentTypeJsonValue)
+		req.Header.Set(headerEventType, headerEventTypeValue)
+		req.Header.Set(headerEventGUID, id)
+		bot.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	}
+
+	// the queued requests are sent before it returns
+	bot.wait()
+	assert.Equal(t, 3, len(received))
+}
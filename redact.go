@@ -0,0 +1,186 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/opensourceways/robot-framework-lib/client"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	redactDrop = "drop"
+	redactHash = "hash"
+)
+
+// redactRule removes the sensitive fields from the payload before it is forwarded,
+// logged or stored.
+type redactRule struct {
+	// Path of the fields separated by dots, "*" matches any key or index and "**"
+	// matches any number of keys, eg "*.author.email" or "**.email".
+	Path string `json:"path"`
+
+	// Action is "drop" which removes the fields, or "hash" which replaces their values
+	// by the SHA-256 digests. Defaults to "drop".
+	Action string `json:"action,omitempty"`
+}
+
+func (r *redactRule) validate() error {
+	if r.Path == "" {
+		return errors.New("redact rule missing path")
+	}
+
+	if slices.Contains(strings.Split(r.Path, "."), "") {
+		return errors.New("redact rule has an invalid path " + r.Path)
+	}
+
+	if r.Action != "" && r.Action != redactDrop && r.Action != redactHash {
+		return errors.New("redact rule has an unknown action " + r.Action)
+	}
+
+	return nil
+}
+
+// redactJSON applies the rules to the JSON body, it is returned as is if nothing is redacted.
+func redactJSON(body []byte, rules []redactRule) ([]byte, error) {
+	if len(rules) == 0 {
+		return body, nil
+	}
+
+	var v any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return nil, errors.New("the payload can not be redacted: " + err.Error())
+	}
+
+	changed := false
+	for i := range rules {
+		if redactValue(v, strings.Split(rules[i].Path, "."), rules[i].Action == redactHash) {
+			changed = true
+		}
+	}
+	if !changed {
+		return body, nil
+	}
+
+	return json.Marshal(v)
+}
+
+// redactValue redacts the fields of v matched by the path, and reports whether any of them is found.
+func redactValue(v any, path []string, hash bool) (changed bool) {
+	if len(path) == 0 {
+		return false
+	}
+
+	if path[0] == "**" {
+		changed = redactValue(v, path[1:], hash)
+		forEachChild(v, func(child any) {
+			if redactValue(child, path, hash) {
+				changed = true
+			}
+		})
+		return
+	}
+
+	switch x := v.(type) {
+	case map[string]any:
+		for k, child := range x {
+			if path[0] != "*" && path[0] != k {
+				continue
+			}
+			if len(path) > 1 {
+				changed = redactValue(child, path[1:], hash) || changed
+				continue
+			}
+			if hash {
+				x[k] = hashValue(child)
+			} else {
+				delete(x, k)
+			}
+			changed = true
+		}
+	case []any:
+		for i, child := range x {
+			if path[0] != "*" && path[0] != strconv.Itoa(i) {
+				continue
+			}
+			if len(path) > 1 {
+				changed = redactValue(child, path[1:], hash) || changed
+				continue
+			}
+			if hash {
+				x[i] = hashValue(child)
+			} else {
+				x[i] = nil
+			}
+			changed = true
+		}
+	}
+
+	return
+}
+
+func forEachChild(v any, fn func(any)) {
+	switch x := v.(type) {
+	case map[string]any:
+		for _, child := range x {
+			fn(child)
+		}
+	case []any:
+		for _, child := range x {
+			fn(child)
+		}
+	}
+}
+
+func hashValue(v any) string {
+	s, ok := v.(string)
+	if !ok {
+		b, _ := json.Marshal(v)
+		s = string(b)
+	}
+	sum := sha256.Sum256([]byte(s))
+
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// loggingFields collects the logging fields of the event redacted by the global rules.
+func (d *delivery) loggingFields() map[string]interface{} {
+	evt := d.event
+	if len(d.redact) > 0 {
+		evt = new(client.GenericEvent)
+		body, err := json.Marshal(d.event)
+		if err == nil {
+			body, err = redactJSON(body, d.redact)
+		}
+		if err == nil {
+			err = json.Unmarshal(body, evt)
+		}
+		if err != nil {
+			return map[string]interface{}{"delivery-id": d.id}
+		}
+	}
+
+	fields := *evt.CollectLoggingFields()
+	fields["delivery-id"] = d.id
+
+	return fields
+}
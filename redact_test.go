@@ -0,0 +1,83 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestRedactJSON(t *testing.T) {
+	body := `{"pr":{"author":{"name":"dummy","email":"dummy@example.com"}},"commits":[{"email":"a@example.com"},{"email":"b@example.com"}],"number":12345678901234567890}`
+	testCases := []struct {
+		no    string
+		rules []redactRule
+		out   []any
+	}{
+		{"case0", nil, []any{body, nil}},
+		{"case1", []redactRule{{Path: "*.author.email"}}, []any{`{"commits":[{"email":"a@example.com"},{"email":"b@example.com"}],"number":12345678901234567890,"pr":{"author":{"name":"dummy"}}}`, nil}},
+		{"case2", []redactRule{{Path: "pr.author.email", Action: redactHash}}, []any{`{"commits":[{"email":"a@example.com"},{"email":"b@example.com"}],"number":12345678901234567890,"pr":{"author":{"email":"sha256:963dd12f8d2f181ee9bef66a67f7b3bd87f47e9e3ecc5b534c85766b227daa28","name":"dummy"}}}`, nil}},
+		{"case3", []redactRule{{Path: "**.email"}}, []any{`{"commits":[{},{}],"number":12345678901234567890,"pr":{"author":{"name":"dummy"}}}`, nil}},
+		{"case4", []redactRule{{Path: "commits.1"}}, []any{`{"commits":[{"email":"a@example.com"},null],"number":12345678901234567890,"pr":{"author":{"email":"dummy@example.com","name":"dummy"}}}`, nil}},
+		{"case5", []redactRule{{Path: "*.committer.email"}}, []any{body, nil}},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			out, err := redactJSON([]byte(body), testCases[i].rules)
+			assert.Equal(t, testCases[i].out[0], string(out))
+			assert.Equal(t, testCases[i].out[1], err)
+		})
+	}
+
+	_, err := redactJSON([]byte("a=b"), []redactRule{{Path: "a"}})
+	assert.Equal(t, errors.New("the payload can not be redacted: invalid character 'a' looking for beginning of value"), err)
+}
+
+func TestEncodeRedact(t *testing.T) {
+	d := newTestDelivery()
+	author := "dummy"
+	d.event.Author = &author
+	d.redact = []redactRule{{Path: "author", Action: redactHash}}
+
+	msg, err := d.encode(&pluginConfig{Name: "serv1", Redact: []redactRule{{Path: "org"}}})
+	assert.Equal(t, nil, err)
+	assert.Contains(t, string(msg.body), `"author":"sha256:`)
+	assert.NotContains(t, string(msg.body), `"org"`)
+	assert.NotContains(t, string(msg.body), "dummy")
+	assert.Equal(t, 1, len(d.redact))
+
+	fields := d.loggingFields()
+	assert.NotEqual(t, "dummy", fields["author"])
+}
+
+func TestEncodeRedactRaw(t *testing.T) {
+	raw := "object_kind=note"
+	d := newDelivery(testDeliveryID, newTestDelivery().event, http.Header{}, newMemorySpool([]byte(raw)))
+	d.redact = []redactRule{{Path: "object_kind"}}
+
+	// the raw payload is forwarded byte for byte, even if it is not JSON
+	msg, err := d.encode(&pluginConfig{Name: "serv1", Payload: payloadRaw})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, raw, string(msg.body))
+}
+
+func TestValidateRedactRaw(t *testing.T) {
+	p := &pluginConfig{
+		Name: "serv1", Endpoint: "http://localhost:7001/gitcode-hook", Payload: payloadRaw,
+		Redact: []redactRule{{Path: "*.author.email"}},
+	}
+	assert.Equal(t, errors.New("serv1 plugin can not redact the raw payload"), p.validate())
+}
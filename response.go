@@ -0,0 +1,68 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+const (
+	outcomeAccepted = "accepted"
+	outcomeDropped  = "dropped"
+	outcomeRejected = "rejected"
+
+	// the reasons of the dropped webhooks
+	reasonNoBinding    = "no_binding"    // no plugin is bound to the org or repo
+	reasonNoSubscriber = "no_subscriber" // no plugin subscribes to the event
+	reasonFiltered     = "filtered"      // the plugins subscribing to the event are not bound to the repo
+
+	// headerRobotDeliveryID carries the delivery ID assigned by the gateway, it is
+	// responded to the webhook caller and forwarded to the plugins.
+	headerRobotDeliveryID = "Robot-Delivery-Id"
+)
+
+// webhookResponse is the body responded to the webhook caller on every outcome.
+// The delivery ID is logged with the webhook, so operators can search for it.
+type webhookResponse struct {
+	DeliveryID string          `json:"deliveryID"`
+	Outcome    string          `json:"outcome"`
+	Reason     string          `json:"reason,omitempty"`
+	Plugins    []string        `json:"plugins,omitempty"`
+	Results    []*pluginResult `json:"results,omitempty"` // the results of the synchronous dispatch
+}
+
+// respond writes the response with the delivery ID of the request.
+func respond(w http.ResponseWriter, status int, resp *webhookResponse) {
+	resp.DeliveryID = w.Header().Get(headerRobotDeliveryID)
+	writeJSON(w, status, resp)
+}
+
+func reject(w http.ResponseWriter, status int, reason string) {
+	respond(w, status, &webhookResponse{Outcome: outcomeRejected, Reason: reason})
+}
+
+// requestLog returns the logger of the request being handled.
+func (bot *robot) requestLog(w http.ResponseWriter) *logrus.Entry {
+	return bot.log.WithField("delivery-id", w.Header().Get(headerRobotDeliveryID))
+}
+
+func pluginNames(plugins []*pluginConfig) []string {
+	names := make([]string, len(plugins))
+	for i, p := range plugins {
+		names[i] = p.Name
+	}
+
+	return names
+}
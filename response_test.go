@@ -0,0 +1,100 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestWebhookResponse(t *testing.T) {
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(headerRobotDeliveryID)
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		no       string
+		bindings map[string][]string
+		events   []string // the events of serv1
+		out      webhookResponse
+	}{
+		{
+			"case0",
+			map[string][]string{"ibforuorg": {"serv1", "serv2"}},
+			[]string{eventComment},
+			webhookResponse{Outcome: outcomeAccepted, Plugins: []string{"serv1"}},
+		},
+		{
+			"case1",
+			map[string][]string{"ibforuorg/test1": {"serv2"}},
+			[]string{eventComment},
+			webhookResponse{Outcome: outcomeDropped, Reason: reasonFiltered},
+		},
+		{
+			"case2",
+			map[string][]string{"other": {"serv1"}},
+			[]string{eventComment},
+			webhookResponse{Outcome: outcomeDropped, Reason: reasonNoBinding},
+		},
+		{
+			"case3",
+			map[string][]string{"ibforuorg": {"serv1", "serv2"}},
+			[]string{eventPush},
+			webhookResponse{Outcome: outcomeDropped, Reason: reasonNoSubscriber},
+		},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			bot := newRobot(&configuration{ConfigItems: accessConfig{
+				RepoPlugins: testCases[i].bindings,
+				Plugins: []pluginConfig{
+					{Name: "serv1", Endpoint: server.URL, Events: testCases[i].events},
+					{Name: "serv2", Endpoint: server.URL, Events: []string{eventPullRequest}},
+				},
+			}})
+
+			data, _ := os.ReadFile(findTestdata(t, "pr_note.json"))
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/response", bytes.NewReader(data))
+			req.Header.Set(headerContentTypeName, headerContentTypeJsonValue)
+			req.Header.Set(headerEventType, headerEventTypeValue)
+			req.Header.Set(headerEventGUID, headerEventGUIDValue)
+			bot.ServeHTTP(w, req)
+			bot.wait()
+
+			assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+			assert.Equal(t, contentTypeJSON, w.Result().Header.Get(headerContentType))
+
+			resp := webhookResponse{}
+			assert.Equal(t, nil, json.NewDecoder(w.Result().Body).Decode(&resp))
+			assert.NotEqual(t, "", resp.DeliveryID)
+			assert.Equal(t, w.Result().Header.Get(headerRobotDeliveryID), resp.DeliveryID)
+
+			want := testCases[i].out
+			want.DeliveryID = resp.DeliveryID
+			assert.Equal(t, want, resp)
+
+			if want.Outcome == outcomeAccepted {
+				assert.Equal(t, resp.DeliveryID, <-received)
+			}
+		})
+	}
+}
@@ -14,14 +14,20 @@
 package main
 
 import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
 	"github.com/go-resty/resty/v2"
 	"github.com/opensourceways/robot-framework-lib/client"
 	"github.com/opensourceways/robot-framework-lib/framework"
 	"github.com/opensourceways/robot-framework-lib/utils"
 	"github.com/sirupsen/logrus"
-	"io"
 	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
@@ -29,85 +35,376 @@ const (
 	noBodyErrorMessage           = "400 Bad Request: request body should be ono-nil"
 	noOrgErrorMessage            = "400 Bad Request: request body not contain owner"
 	noRepoErrorMessage           = "400 Bad Request: request body not contain repo"
+	bodyReadErrorMessage         = "400 Bad Request: Failed to read request body"
+	decompressErrorMessage       = "400 Bad Request: Failed to decompress request body"
+	bodyTooLargeErrorMessage     = "413 Request Entity Too Large: request body exceeds the limit"
+	tooManyRequestsErrorMessage  = "429 Too Many Requests: rate limit exceeded"
+	configNotLoadedErrorMessage  = "503 Service Unavailable: the configmap is not loaded"
+	shuttingDownErrorMessage     = "503 Service Unavailable: the gateway is shutting down"
+
+	unsupportedEncodingErrorMessage = "415 Unsupported Media Type: Content-Encoding must be gzip or zstd"
+
+	// headerRobotEventType carries the canonical event type to the plugins
+	headerRobotEventType = "Robot-Event-Type"
 )
 
 func newRobot(c *configuration) *robot {
 	logger := framework.NewLogger().WithField("component", component)
+	client := resty.New().RemoveProxy().SetRetryCount(retryCount).SetLogger(logger.WithField("module", "resty"))
 	return &robot{
-		client:    resty.New().RemoveProxy().SetRetryCount(2).SetLogger(logger.WithField("module", "resty")),
+		client:    client,
 		configmap: c,
 		log:       logger,
+		sinks:     map[string]sink{},
+		throttle:  newThrottle(),
+		sequencer: newSequencer(),
+		debouncer: newDebouncer(),
+		health:    newPluginHealths(c, newProbeClient(client)),
+		inflight:  newInflight(),
+
+		subscriptions: newSubscriptions(c, logger),
 	}
 }
 
 type robot struct {
 	client    *resty.Client
 	configmap *configuration
-	event     *client.GenericEvent
 	log       *logrus.Entry
 	wg        sync.WaitGroup
+	sinks     map[string]sink
+	sinkLock  sync.Mutex
+	throttle  *throttle
+	sequencer *sequencer
+	debouncer *debouncer
+	draining  atomic.Bool // set once the shutdown begins
+	health    map[string]*pluginHealth
+	inflight  *inflight // the messages not sent yet, they are persisted if the drain times out
+
+	subscriptions *subscriptions
 }
 
 func (bot *robot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(headerRobotDeliveryID, newDeliveryID())
+	if bot.configmap == nil {
+		bot.requestLog(w).Error(errConfigNotLoaded.Error())
+		reject(w, http.StatusServiceUnavailable, configNotLoadedErrorMessage)
+		return
+	}
 
-	evt := client.NewGenericEvent(w, r, bot.log)
-	if utils.GetString(evt.EventType) == "" {
-		bot.log.Warning(missingEventTypeErrorMessage)
-		http.Error(w, missingEventTypeErrorMessage, http.StatusBadRequest)
+	// the forge retries the webhook, which is accepted by the next instance
+	if bot.draining.Load() {
+		w.Header().Set("Retry-After", strconv.Itoa(int(shutdownRetryAfter.Seconds())))
+		reject(w, http.StatusServiceUnavailable, shuttingDownErrorMessage)
 		return
 	}
 
-	if evt.GetMetaPayload() == nil {
-		bot.log.Warning(noBodyErrorMessage)
-		http.Error(w, noBodyErrorMessage, http.StatusBadRequest)
+	var keys []throttleKey
+	if limits := &bot.configmap.ConfigItems.RateLimits; limits.SourceIP != nil {
+		keys = append(keys, throttleKey{scope: scopeSourceIP, key: limits.sourceIP(r), limit: limits.SourceIP})
+	}
+	delay, cancel, ok := bot.throttled(w, keys)
+	if !ok {
 		return
 	}
 
-	if utils.GetString(evt.Org) == "" {
-		bot.log.Warning(noOrgErrorMessage)
-		http.Error(w, noOrgErrorMessage, http.StatusBadRequest)
+	// keep the original body, it may be forwarded byte-for-byte
+	raw, ok := bot.readBody(w, r)
+	if !ok {
 		return
 	}
+	if !bot.accept(w, r, raw, delay, cancel) {
+		raw.remove()
+	}
+}
+
+// readBody reads the body of the webhook, which is decompressed if necessary.
+func (bot *robot) readBody(w http.ResponseWriter, r *http.Request) (*spool, bool) {
+	if r.Body == nil {
+		return newMemorySpool(nil), true
+	}
+	defer r.Body.Close()
+
+	limits := &bot.configmap.ConfigItems.Limits
+	limit := limits.capacity()
+	encoding := r.Header.Get(headerContentEncoding)
+	if encoding == "" && r.ContentLength > limit {
+		bot.rejectTooLarge(w, "")
+		return nil, false
+	}
+
+	body, err := decompressReader(encoding, http.MaxBytesReader(w, r.Body, limit))
+	if err == nil {
+		defer body.Close()
+
+		var raw *spool
+		if raw, err = newSpool(body, limit, limits.SpillThreshold.or(defaultSpillThreshold), limits.SpillDir); err == nil {
+			r.Header.Del(headerContentEncoding)
+			r.ContentLength = raw.size
+			return raw, true
+		}
+	}
+
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(err, errBodyTooLarge) || errors.As(err, &tooLarge):
+		bot.rejectTooLarge(w, "")
+	case errors.Is(err, errUnsupportedEncoding):
+		bot.requestLog(w).WithError(err).Warning(decompressErrorMessage)
+		w.Header().Set(headerAcceptEncoding, encodingGzip+", "+encodingZstd)
+		reject(w, http.StatusUnsupportedMediaType, unsupportedEncodingErrorMessage)
+	case encoding != "":
+		bot.requestLog(w).WithError(err).Warning(decompressErrorMessage)
+		reject(w, http.StatusBadRequest, decompressErrorMessage)
+	default:
+		bot.requestLog(w).WithError(err).Warning(bodyReadErrorMessage)
+		reject(w, http.StatusBadRequest, bodyReadErrorMessage)
+	}
+
+	return nil, false
+}
+
+// rejectTooLarge rejects the webhook of the org, which is empty if it is not parsed yet.
+func (bot *robot) rejectTooLarge(w http.ResponseWriter, org string) {
+	label := org
+	if org != "" {
+		label = bot.configmap.ConfigItems.orgLabel(org)
+	}
+	rejectedRequests.WithLabelValues(rejectBodyTooLarge, label).Inc()
+	bot.requestLog(w).WithField("org", org).Warning(bodyTooLargeErrorMessage)
+	reject(w, http.StatusRequestEntityTooLarge, bodyTooLargeErrorMessage)
+}
+
+// accept validates the webhook and dispatches it after the delay, the raw body is
+// owned by the delivery if it returns true. The tokens of the source IP are given back
+// by cancelSourceIP if the repository rejects it by its rate limits.
+func (bot *robot) accept(w http.ResponseWriter, r *http.Request, raw *spool, delay time.Duration, cancelSourceIP func()) bool {
+	body, err := raw.open()
+	if err != nil {
+		bot.requestLog(w).WithError(err).Warning(bodyReadErrorMessage)
+		reject(w, http.StatusBadRequest, bodyReadErrorMessage)
+		return false
+	}
+	r.Body = body
+	defer body.Close()
+
+	evt := client.NewGenericEvent(w, r, bot.requestLog(w))
+	if utils.GetString(evt.EventType) == "" {
+		bot.requestLog(w).Warning(missingEventTypeErrorMessage)
+		reject(w, http.StatusBadRequest, missingEventTypeErrorMessage)
+		return false
+	}
+
+	if evt.GetMetaPayload() == nil {
+		bot.requestLog(w).Warning(noBodyErrorMessage)
+		reject(w, http.StatusBadRequest, noBodyErrorMessage)
+		return false
+	}
+
+	if utils.GetString(evt.Org) == "" {
+		bot.requestLog(w).Warning(noOrgErrorMessage)
+		reject(w, http.StatusBadRequest, noOrgErrorMessage)
+		return false
+	}
 
 	if utils.GetString(evt.Repo) == "" {
-		bot.log.Warning(noRepoErrorMessage)
-		http.Error(w, noRepoErrorMessage, http.StatusBadRequest)
-		return
+		bot.requestLog(w).Warning(noRepoErrorMessage)
+		reject(w, http.StatusBadRequest, noRepoErrorMessage)
+		return false
 	}
-	bot.event = evt
-	endpoints := bot.configmap.GetEndpoints(*evt.Org, *evt.Repo, *evt.EventType)
-	if len(endpoints) == 0 {
-		bot.log.WithField("request", "drop").Warning("there is no endpoint to dispatch this request")
-		return
+
+	if raw.size > bot.configmap.ConfigItems.Limits.maxBodySizeOf(*evt.Org) {
+		bot.rejectTooLarge(w, *evt.Org)
+		return false
+	}
+	events := routedEventTypes(*evt.EventType, evt.GetMetaPayload().Bytes())
+	if raw.spilled() {
+		// the parsed payload is dropped, the raw one is streamed from the file during the fan-out
+		*evt.GetMetaPayload() = bytes.Buffer{}
+	}
+	plugins := bot.configmap.GetPlugins(*evt.Org, *evt.Repo, events...)
+	if len(plugins) == 0 {
+		reason := bot.configmap.dropReason(*evt.Org, *evt.Repo, events...)
+		bot.requestLog(w).WithField("request", "drop").WithField("reason", reason).
+			Warning("there is no endpoint to dispatch this request")
+		respond(w, http.StatusOK, &webhookResponse{Outcome: outcomeDropped, Reason: reason})
+		return false
+	}
+
+	repoDelay, _, ok := bot.throttled(w, bot.configmap.ConfigItems.RateLimits.repoKeys(*evt.Org, *evt.Repo))
+	if !ok {
+		cancelSourceIP()
+		return false
 	}
 
 	bot.wg.Add(1)
-	r.Header.Set(client.HeaderRobotChain, client.HeaderRobotChainAuthed)
-	go bot.dispatcher(&r.Header, endpoints)
+	if canonical, ok := canonicalOf(events); ok {
+		r.Header.Set(headerRobotEventType, canonical)
+	}
+	d := newDelivery(w.Header().Get(headerRobotDeliveryID), evt, r.Header.Clone(), raw)
+	d.redact = bot.configmap.ConfigItems.Redact
+	d.notBefore = d.received.Add(max(delay, repoDelay))
+	for _, p := range plugins {
+		if p.Ordering != "" {
+			if d.tickets == nil {
+				d.tickets = map[string]*ticket{}
+			}
+			d.tickets[p.Name] = bot.sequencer.take(p.Name + ":" + d.orderingKey(p.Ordering))
+		}
+	}
+
+	if route := bot.configmap.ConfigItems.syncRouteOf(*evt.Org, *evt.Repo, events...); route != nil {
+		d.sync = true
+		bot.syncDispatcher(w, d, plugins, route)
+		return true
+	}
+	go bot.dispatcher(d, plugins)
+	respond(w, http.StatusOK, &webhookResponse{Outcome: outcomeAccepted, Plugins: pluginNames(plugins)})
+
+	return true
+}
+
+// delivery is an accepted webhook which is dispatched to the plugins.
+type delivery struct {
+	id       string
+	event    *client.GenericEvent
+	header   http.Header
+	raw      *spool // the original body of the webhook
+	received time.Time
+	redact   []redactRule // the global redaction policy
+
+	// notBefore is when the delivery is allowed by the inbound rate limits
+	notBefore time.Time
+
+	// tickets are the turns of the plugins requiring the ordered delivery
+	tickets map[string]*ticket
+
+	// sync is set if the caller waits for the results of the plugins
+	sync bool
 }
 
-func (bot *robot) wait() {
-	bot.wg.Wait() // Handle remaining requests
+func newDelivery(id string, evt *client.GenericEvent, h http.Header, raw *spool) *delivery {
+	h.Set(headerRobotDeliveryID, id)
+
+	return &delivery{id: id, event: evt, header: h, raw: raw, received: time.Now()}
 }
 
-func (bot *robot) dispatcher(h *http.Header, endpoints []string) {
+// newDeliveryID generates a random UUID (version 4).
+func newDeliveryID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func (bot *robot) dispatcher(d *delivery, plugins []*pluginConfig) {
 	defer bot.wg.Done()
-	logger := bot.log.WithFields(*bot.event.CollectLoggingFields())
-	for _, uri := range endpoints {
+	defer d.raw.remove()
 
-		req := bot.client.R()
-		req.Header = *h
-		req.SetBody(bot.event)
+	if wait := time.Until(d.notBefore); wait > 0 {
+		// the throttled deliveries are persisted as well if the shutdown times out
+		untrack := bot.trackDelayed(d, plugins)
+		time.Sleep(wait)
+		untrack()
+	}
 
-		resp, err := req.Post(uri)
-		if err != nil {
-			logger.WithError(err).Error("failed to send to " + uri)
-			continue
-		}
-		if resp != nil {
-			_, _ = io.Copy(io.Discard, resp.RawBody())
+	logger := bot.log.WithFields(d.loggingFields())
+	for _, p := range plugins {
+		bot.dispatch(d, p, logger, func(int, error) {})
+	}
+
+}
+
+// dispatch delivers the delivery to a plugin, done is called with the status code
+// responded by the plugin, if any, and the error once it is sent or failed.
+func (bot *robot) dispatch(d *delivery, p *pluginConfig, logger *logrus.Entry, done func(int, error)) {
+	uri := p.location()
+	t := d.tickets[p.Name]
+
+	msg, err := d.encode(p)
+	if err != nil {
+		t.skip()
+		logger.WithError(err).Error("failed to encode the request for " + uri)
+		done(0, err)
+		return
+	}
+	// the spilled payload is kept until the message is sent
+	if msg.stream != nil {
+		msg.stream.retain()
+		next := done
+		done = func(code int, err error) {
+			msg.stream.remove()
+			next(code, err)
 		}
-		logger.Info("the request is successfully sent to URL[" + uri + "]")
 	}
 
+	s, err := bot.sinkOf(p)
+	if err != nil {
+		t.skip()
+		logger.WithError(err).Error("failed to connect to " + uri)
+		done(0, err)
+		return
+	}
+
+	// the synchronous deliveries are not held, the caller is waiting for them
+	if !d.sync && p.Debounce != nil && p.Debounce.applies(msg.eventType, msg.header.Get(headerRobotEventType)) {
+		bot.debounce(d, p, s, msg, logger, uri)
+		done(0, nil)
+		return
+	}
+
+	if t == nil {
+		bot.send(s, msg, logger, uri, done)
+		return
+	}
+
+	// the ordered events wait for their turns without blocking the other plugins
+	bot.wg.Add(1)
+	go func() {
+		defer bot.wg.Done()
+
+		untrack := bot.inflight.track(msg)
+		t.wait()
+		bot.send(s, msg, logger, uri, func(code int, err error) {
+			t.release()
+			done(code, err)
+		})
+		untrack()
+	}()
+}
+
+// send delivers the message to the sink, done is called once it is sent or failed.
+func (bot *robot) send(s sink, msg *message, logger *logrus.Entry, uri string, done func(int, error)) {
+	untrack := bot.inflight.track(msg)
+
+	q, ok := s.(asyncSink)
+	if !ok {
+		code, err := sendStatus(s, msg)
+		untrack()
+		reportDelivery(logger, uri, err)
+		done(code, err)
+		return
+	}
+
+	bot.wg.Add(1)
+	err := q.enqueue(msg, func(err error) {
+		defer bot.wg.Done()
+		untrack()
+		reportDelivery(logger, uri, err)
+		done(statusOf(err), err)
+	})
+	if err != nil {
+		bot.wg.Done()
+		untrack()
+		logger.WithError(err).Error("failed to queue the request for " + uri)
+		done(0, err)
+	}
+}
+
+func reportDelivery(logger *logrus.Entry, uri string, err error) {
+	if err != nil {
+		logger.WithError(err).Error("failed to send to " + uri)
+		return
+	}
+	logger.Info("the request is successfully sent to URL[" + uri + "]")
 }
@@ -17,13 +17,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"flag"
+	"github.com/opensourceways/robot-framework-lib/client"
 	"github.com/opensourceways/server-common-lib/interrupts"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
-	"strings"
 	"testing"
 	"time"
 )
@@ -105,9 +105,20 @@ func TestDispatcherFail(t *testing.T) {
 		"--handle-path=gitcode-hook",
 	}
 
+	delivered := make(chan []byte, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		delivered <- body
+	}))
+	defer server.Close()
+
 	opt := new(robotOptions)
 	cnf := opt.gatherOptions(flag.NewFlagSet(args[0], flag.ExitOnError), args[1:]...)
+	// the webhook is delivered to the recording plugin
+	cnf.ConfigItems.RepoPlugins["ibforuorg/test1"] = []string{"service-name1"}
+	cnf.ConfigItems.Plugins[0].Endpoint = server.URL
 	bot := newRobot(cnf)
+	defer bot.wait()
 
 	data, _ := os.ReadFile(findTestdata(t, "pr_note.json"))
 	buf := &bytes.Buffer{}
@@ -121,8 +132,13 @@ func TestDispatcherFail(t *testing.T) {
 	req.Header.Set(headerEventGUID, headerEventGUIDValue)
 	bot.ServeHTTP(w, req)
 
+	// the delivered message is the parsed event, which is posted again by the chained robot
+	evt := client.GenericEvent{}
+	assert.Equal(t, nil, json.Unmarshal(<-delivered, &evt))
+	assert.Equal(t, "test1", *evt.Repo)
+
 	buf1 := &bytes.Buffer{}
-	err := json.NewEncoder(buf1).Encode(bot.event)
+	err := json.NewEncoder(buf1).Encode(&evt)
 	assert.Equal(t, nil, err)
 	w1 := httptest.NewRecorder()
 	req1, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/case3", buf1)
@@ -132,8 +148,8 @@ func TestDispatcherFail(t *testing.T) {
 	req1.Header.Set(headerRobotChain, headerRobotChainAuthed)
 	bot.ServeHTTP(w1, req1)
 
-	bot.event.Repo = nil
-	err = json.NewEncoder(buf1).Encode(bot.event)
+	evt.Repo = nil
+	err = json.NewEncoder(buf1).Encode(&evt)
 	assert.Equal(t, nil, err)
 	w3 := httptest.NewRecorder()
 	req3, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/case5", buf1)
@@ -143,12 +159,10 @@ func TestDispatcherFail(t *testing.T) {
 	req3.Header.Set(headerRobotChain, headerRobotChainAuthed)
 	bot.ServeHTTP(w3, req3)
 	assert.Equal(t, http.StatusBadRequest, w3.Result().StatusCode)
-	var str strings.Builder
-	_, _ = io.Copy(&str, w3.Result().Body)
-	assert.Equal(t, noRepoErrorMessage+"\n", str.String())
+	assertRejected(t, w3, noRepoErrorMessage)
 
-	bot.event.Org = nil
-	err = json.NewEncoder(buf1).Encode(bot.event)
+	evt.Org = nil
+	err = json.NewEncoder(buf1).Encode(&evt)
 	assert.Equal(t, nil, err)
 	w4 := httptest.NewRecorder()
 	req4, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/case5", buf1)
@@ -158,12 +172,10 @@ func TestDispatcherFail(t *testing.T) {
 	req4.Header.Set(headerRobotChain, headerRobotChainAuthed)
 	bot.ServeHTTP(w4, req4)
 	assert.Equal(t, http.StatusBadRequest, w4.Result().StatusCode)
-	var str2 strings.Builder
-	_, _ = io.Copy(&str2, w4.Result().Body)
-	assert.Equal(t, noOrgErrorMessage+"\n", str2.String())
+	assertRejected(t, w4, noOrgErrorMessage)
 
-	bot.event.EventType = nil
-	err = json.NewEncoder(buf1).Encode(bot.event)
+	evt.EventType = nil
+	err = json.NewEncoder(buf1).Encode(&evt)
 	assert.Equal(t, nil, err)
 	w2 := httptest.NewRecorder()
 	req2, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/case4", buf1)
@@ -174,7 +186,13 @@ func TestDispatcherFail(t *testing.T) {
 	bot.ServeHTTP(w2, req2)
 
 	assert.Equal(t, http.StatusBadRequest, w2.Result().StatusCode)
-	var str1 strings.Builder
-	_, _ = io.Copy(&str1, w2.Result().Body)
-	assert.Equal(t, missingEventTypeErrorMessage+"\n", str1.String())
+	assertRejected(t, w2, missingEventTypeErrorMessage)
+}
+
+func assertRejected(t *testing.T, w *httptest.ResponseRecorder, reason string) {
+	resp := webhookResponse{}
+	assert.Equal(t, nil, json.NewDecoder(w.Result().Body).Decode(&resp))
+	assert.Equal(t, outcomeRejected, resp.Outcome)
+	assert.Equal(t, reason, resp.Reason)
+	assert.Equal(t, w.Result().Header.Get(headerRobotDeliveryID), resp.DeliveryID)
 }
@@ -0,0 +1,375 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDrainTimeout = 30 * time.Second
+	// maxDrainTimeout is the grace period the interrupts package waits for the workers
+	maxDrainTimeout       = time.Minute
+	drainProgressInterval = 5 * time.Second
+
+	// shutdownRetryAfter is responded to the webhooks rejected by the shutdown
+	shutdownRetryAfter = 10 * time.Second
+
+	persistedFileSuffix = ".json"
+)
+
+// shutdownConfig bounds the drain of the deliveries on the shutdown. The deliveries
+// not finished before the deadline are persisted, and redelivered by the next instance
+// sharing the same directory.
+type shutdownConfig struct {
+	// DrainTimeout is how long the in-flight deliveries are waited for. Defaults to "30s",
+	// which must be shorter than the termination grace period of the pod and than
+	// the one minute the server waits for its workers on the shutdown.
+	DrainTimeout duration `json:"drain_timeout,omitempty"`
+
+	// PersistDir is the directory of the unfinished deliveries, eg a persistent volume.
+	// They are lost if it is not set.
+	PersistDir string `json:"persist_dir,omitempty"`
+}
+
+func (c *shutdownConfig) validate() error {
+	if c.DrainTimeout < 0 {
+		return errors.New("the drain timeout of the shutdown can not be negative")
+	}
+
+	if time.Duration(c.DrainTimeout) >= maxDrainTimeout {
+		return errors.New("the drain timeout of the shutdown must be less than " + maxDrainTimeout.String())
+	}
+
+	return nil
+}
+
+// inflight is the set of the messages not sent yet. A message is counted as many
+// times as it is tracked.
+type inflight struct {
+	lock sync.Mutex
+	msgs map[*message]int
+}
+
+func newInflight() *inflight {
+	return &inflight{msgs: map[*message]int{}}
+}
+
+// track adds the message, and returns the func removing it.
+func (f *inflight) track(msg *message) (untrack func()) {
+	f.lock.Lock()
+	f.msgs[msg]++
+	f.lock.Unlock()
+
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			f.lock.Lock()
+			defer f.lock.Unlock()
+
+			if f.msgs[msg]--; f.msgs[msg] <= 0 {
+				delete(f.msgs, msg)
+			}
+		})
+	}
+}
+
+func (f *inflight) len() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return len(f.msgs)
+}
+
+func (f *inflight) list() []*message {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	msgs := make([]*message, 0, len(f.msgs))
+	for msg := range f.msgs {
+		msgs = append(msgs, msg)
+	}
+
+	return msgs
+}
+
+// trackDelayed tracks the messages of the delivery waiting before being dispatched,
+// and returns the func removing them.
+func (bot *robot) trackDelayed(d *delivery, plugins []*pluginConfig) (untrack func()) {
+	var untracks []func()
+	for _, p := range plugins {
+		// the failure is reported once the delivery is dispatched
+		if msg, err := d.encode(p); err == nil {
+			untracks = append(untracks, bot.inflight.track(msg))
+		}
+	}
+
+	return func() {
+		for _, f := range untracks {
+			f()
+		}
+	}
+}
+
+func (bot *robot) shutdownConfig() shutdownConfig {
+	if bot.configmap == nil {
+		return shutdownConfig{}
+	}
+
+	return bot.configmap.ConfigItems.Shutdown
+}
+
+// drainingServer shuts down the robot before the server, so the webhooks arriving
+// during the drain are still answered, with 503, rather than refused.
+type drainingServer struct {
+	*http.Server
+	bot *robot
+
+	// the server serves TLS if they are set
+	certFile string
+	keyFile  string
+}
+
+func (s *drainingServer) ListenAndServe() error {
+	if s.certFile != "" {
+		return s.Server.ListenAndServeTLS(s.certFile, s.keyFile)
+	}
+
+	return s.Server.ListenAndServe()
+}
+
+func (s *drainingServer) Shutdown(ctx context.Context) error {
+	s.bot.wait()
+
+	return s.Server.Shutdown(ctx)
+}
+
+// wait shuts down the robot. The new webhooks are rejected, and the accepted ones are
+// drained until the deadline, after which the unfinished deliveries are persisted.
+func (bot *robot) wait() {
+	bot.draining.Store(true)
+	// the plugins are probed during the drain, the deliveries held for the unhealthy
+	// ones are sent once they recover, otherwise they are persisted
+	defer func() {
+		for _, h := range bot.health {
+			h.close()
+		}
+	}()
+
+	cfg := bot.shutdownConfig()
+	logger := bot.log.WithField("module", "shutdown")
+	logger.WithField("pending", bot.inflight.len()).Info("draining the deliveries")
+
+	drained := make(chan struct{})
+	go func() {
+		bot.wg.Wait() // Handle remaining requests
+		close(drained)
+	}()
+
+	if !bot.drain(drained, cfg.DrainTimeout.or(defaultDrainTimeout), logger) {
+		// the sinks are not closed, they may be blocked by the stuck plugins
+		msgs := append(bot.inflight.list(), bot.debouncer.pending()...)
+		logger.WithField("pending", len(msgs)).Warning("the drain timed out")
+		bot.persist(cfg.PersistDir, append(msgs, bot.subscriptions.pending()...))
+		return
+	}
+
+	bot.closeSinks()
+	// the buffered events of the subscriptions are never drained, they wait for the plugins
+	bot.persist(cfg.PersistDir, bot.subscriptions.pending())
+	logger.Info("the deliveries are drained")
+}
+
+// drain waits until it is drained or the timeout, the progress is logged meanwhile.
+func (bot *robot) drain(drained <-chan struct{}, timeout time.Duration, logger *logrus.Entry) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	ticker := time.NewTicker(drainProgressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-drained:
+			return true
+		case <-deadline.C:
+			return false
+		case <-ticker.C:
+			logger.Info("draining the deliveries, " + strconv.Itoa(bot.inflight.len()) + " pending")
+		}
+	}
+}
+
+func (s *subscriptions) pending() []*message {
+	var msgs []*message
+	for _, sub := range s.items {
+		msgs = append(msgs, sub.pending()...)
+	}
+
+	return msgs
+}
+
+// persistedMessage is a delivery to a plugin persisted by the shutdown.
+type persistedMessage struct {
+	Plugin    string      `json:"plugin"`
+	ID        string      `json:"id"`
+	EventType string      `json:"eventType"`
+	Org       string      `json:"org"`
+	Repo      string      `json:"repo"`
+	Header    http.Header `json:"header"`
+	Body      []byte      `json:"body"`
+}
+
+func (m *persistedMessage) message() *message {
+	return &message{
+		header: m.Header, body: m.Body, id: m.ID, eventType: m.EventType,
+		org: m.Org, repo: m.Repo, plugin: m.Plugin,
+	}
+}
+
+// persist writes the messages to the directory, one file per message.
+func (bot *robot) persist(dir string, msgs []*message) {
+	logger := bot.log.WithField("module", "shutdown")
+	if len(msgs) == 0 {
+		return
+	}
+	if dir == "" {
+		logger.WithField("lost", len(msgs)).Error("the unfinished deliveries are lost, the persist dir is not set")
+		return
+	}
+
+	n := 0
+	for i, msg := range msgs {
+		if msg == nil {
+			continue
+		}
+
+		body, err := msg.payload()
+		if err == nil {
+			name := msg.id + "-" + url.PathEscape(msg.plugin) + "-" + strconv.Itoa(i) + persistedFileSuffix
+			err = writeFileAtomic(filepath.Join(dir, name), &persistedMessage{
+				Plugin: msg.plugin, ID: msg.id, EventType: msg.eventType,
+				Org: msg.org, Repo: msg.repo, Header: msg.header, Body: body,
+			})
+		}
+		if err != nil {
+			logger.WithError(err).WithField("delivery-id", msg.id).Error("failed to persist the delivery to " + msg.plugin)
+			continue
+		}
+		n++
+	}
+
+	logger.WithField("persisted", n).Info("the unfinished deliveries are persisted to " + dir)
+}
+
+// writeFileAtomic writes v as JSON to a temporary file which is renamed to the path,
+// so a half written file is never recovered.
+func writeFileAtomic(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// recoverDeliveries redelivers the deliveries persisted by the last instance. A file
+// is removed before it is redelivered, so it is recovered by only one instance.
+func (bot *robot) recoverDeliveries() {
+	dir := bot.shutdownConfig().PersistDir
+	if dir == "" || bot.configmap == nil {
+		return
+	}
+
+	logger := bot.log.WithField("module", "shutdown")
+	files, err := filepath.Glob(filepath.Join(dir, "*"+persistedFileSuffix))
+	if err != nil {
+		logger.WithError(err).Error("failed to list the persisted deliveries")
+		return
+	}
+
+	n := 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		if err = os.Remove(file); err != nil {
+			// it is recovered by another instance
+			continue
+		}
+
+		m := persistedMessage{}
+		if err = json.Unmarshal(data, &m); err != nil {
+			logger.WithError(err).Error("failed to parse the persisted delivery " + file)
+			continue
+		}
+
+		if !bot.redeliver(m.message()) {
+			continue
+		}
+		n++
+	}
+
+	if n > 0 {
+		logger.WithField("recovered", n).Info("the persisted deliveries are recovered from " + dir)
+	}
+}
+
+// redeliver sends the recovered message to its plugin, which must still be configured.
+func (bot *robot) redeliver(msg *message) bool {
+	logger := bot.log.WithField("delivery-id", msg.id).WithField("recovered", true)
+
+	var p *pluginConfig
+	for i := range bot.configmap.ConfigItems.Plugins {
+		if bot.configmap.ConfigItems.Plugins[i].Name == msg.plugin {
+			p = &bot.configmap.ConfigItems.Plugins[i]
+		}
+	}
+	if p == nil {
+		logger.Warning("the recovered delivery is dropped, there is no plugin " + msg.plugin)
+		return false
+	}
+
+	uri := p.location()
+	s, err := bot.sinkOf(p)
+	if err != nil {
+		logger.WithError(err).Error("failed to connect to " + uri)
+		return false
+	}
+
+	bot.wg.Add(1)
+	go func() {
+		defer bot.wg.Done()
+
+		bot.send(s, msg, logger, uri, func(int, error) {})
+	}()
+
+	return true
+}
@@ -0,0 +1,190 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newShutdownRequest(t *testing.T) *http.Request {
+	data, _ := os.ReadFile(findTestdata(t, "pr_note.json"))
+	req, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/shutdown", bytes.NewReader(data))
+	req.Header.Set(headerContentTypeName, headerContentTypeJsonValue)
+	req.Header.Set(headerEventType, headerEventTypeValue)
+	req.Header.Set(headerEventGUID, headerEventGUIDValue)
+
+	return req
+}
+
+func newShutdownRobot(endpoint, dir string) *robot {
+	return newRobot(&configuration{ConfigItems: accessConfig{
+		RepoPlugins: map[string][]string{"ibforuorg": {"serv1"}},
+		Plugins:     []pluginConfig{{Name: "serv1", Endpoint: endpoint, Events: []string{eventComment}}},
+		Shutdown:    shutdownConfig{DrainTimeout: duration(200 * time.Millisecond), PersistDir: dir},
+	}})
+}
+
+func TestShutdownPersistAndRecover(t *testing.T) {
+	dir := t.TempDir()
+
+	// the plugin is stuck until the test ends
+	arrived := make(chan struct{}, 1)
+	release := make(chan struct{})
+	stuck := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+	}))
+	defer stuck.Close()
+	defer close(release)
+
+	bot := newShutdownRobot(stuck.URL, dir)
+	w := httptest.NewRecorder()
+	bot.ServeHTTP(w, newShutdownRequest(t))
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	id := w.Result().Header.Get(headerRobotDeliveryID)
+	<-arrived
+
+	start := time.Now()
+	bot.wait()
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+persistedFileSuffix))
+	assert.Equal(t, 1, len(files))
+
+	// the new webhooks are rejected once it is draining
+	w = httptest.NewRecorder()
+	bot.ServeHTTP(w, newShutdownRequest(t))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Result().StatusCode)
+	assert.NotEqual(t, "", w.Result().Header.Get("Retry-After"))
+	assertRejected(t, w, shuttingDownErrorMessage)
+
+	// the next instance redelivers it
+	received := make(chan *http.Request, 1)
+	var body []byte
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		received <- r
+	}))
+	defer healthy.Close()
+
+	next := newShutdownRobot(healthy.URL, dir)
+	next.recoverDeliveries()
+	next.wait()
+
+	r := <-received
+	assert.Equal(t, id, r.Header.Get(headerRobotDeliveryID))
+	assert.NotEqual(t, 0, len(body))
+
+	files, _ = filepath.Glob(filepath.Join(dir, "*"))
+	assert.Equal(t, 0, len(files))
+}
+
+func TestShutdownDrained(t *testing.T) {
+	dir := t.TempDir()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	bot := newShutdownRobot(server.URL, dir)
+	bot.ServeHTTP(httptest.NewRecorder(), newShutdownRequest(t))
+	bot.wait()
+
+	assert.Equal(t, 0, bot.inflight.len())
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	assert.Equal(t, 0, len(files))
+}
+
+func TestShutdownPersistDelayed(t *testing.T) {
+	dir := t.TempDir()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// the delivery is delayed by the rate limits beyond the drain timeout
+	bot := newShutdownRobot(server.URL, dir)
+	data, _ := os.ReadFile(findTestdata(t, "pr_note.json"))
+	req := newShutdownRequest(t)
+	w := httptest.NewRecorder()
+	w.Header().Set(headerRobotDeliveryID, newDeliveryID())
+	assert.Equal(t, true, bot.accept(w, req, newMemorySpool(data), time.Second, func() {}))
+	bot.wait()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+persistedFileSuffix))
+	assert.Equal(t, 1, len(files))
+	bot.wg.Wait()
+}
+
+func TestShutdownPersistHeld(t *testing.T) {
+	dir := t.TempDir()
+	posts := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts <- struct{}{}
+	}))
+	defer server.Close()
+
+	// the plugin is down until the drain times out
+	bot := newShutdownRobot(server.URL, dir)
+	h := newPluginHealth("serv1", &healthCheckConfig{UnhealthyThreshold: 1})
+	h.observe(errors.New("down"), time.Now())
+	bot.health["serv1"] = h
+	bot.configmap.ConfigItems.Plugins[0].HealthCheck = &healthCheckConfig{URL: server.URL}
+
+	w := httptest.NewRecorder()
+	bot.ServeHTTP(w, newShutdownRequest(t))
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	bot.wait()
+
+	assert.Equal(t, 0, len(posts))
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+persistedFileSuffix))
+	assert.Equal(t, 1, len(files))
+}
+
+func TestDrainingServer(t *testing.T) {
+	bot := newShutdownRobot("http://localhost:8080/hook", "")
+	listener := httptest.NewUnstartedServer(bot)
+	server := &drainingServer{Server: listener.Config, bot: bot}
+
+	// the robot is draining before the server is shut down
+	assert.Equal(t, nil, server.Shutdown(context.Background()))
+	assert.Equal(t, true, bot.draining.Load())
+	assert.Equal(t, http.ErrServerClosed, server.ListenAndServe())
+}
+
+func TestShutdownPersistSubscription(t *testing.T) {
+	dir := t.TempDir()
+	bot := newRobot(&configuration{ConfigItems: accessConfig{
+		RepoPlugins: map[string][]string{"ibforuorg": {"serv1"}},
+		Plugins:     []pluginConfig{{Name: "serv1", Mode: modePull, Events: []string{eventComment}}},
+		Shutdown:    shutdownConfig{PersistDir: dir},
+	}})
+	bot.ServeHTTP(httptest.NewRecorder(), newShutdownRequest(t))
+	bot.wait()
+
+	// the buffered event is never pulled, it is recovered by the next instance
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+persistedFileSuffix))
+	assert.Equal(t, 1, len(files))
+
+	next := newRobot(bot.configmap)
+	next.recoverDeliveries()
+	next.wg.Wait()
+	assert.Equal(t, 1, len(next.subscriptions.items["serv1"].pending()))
+}
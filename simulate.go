@@ -0,0 +1,347 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/opensourceways/robot-framework-lib/client"
+	"github.com/opensourceways/robot-framework-lib/utils"
+	commonutils "github.com/opensourceways/server-common-lib/utils"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const simulateCommand = "simulate"
+
+// headerFlags collects the repeated flag "-header 'Name: value'".
+type headerFlags http.Header
+
+func (h headerFlags) String() string {
+	return ""
+}
+
+func (h headerFlags) Set(v string) error {
+	name, value, ok := strings.Cut(v, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return errors.New("the header must be 'Name: value'")
+	}
+	http.Header(h).Add(strings.TrimSpace(name), strings.TrimSpace(value))
+
+	return nil
+}
+
+// simulation is how a webhook would be dispatched by the configmap.
+type simulation struct {
+	Org            string               `json:"org"`
+	Repo           string               `json:"repo"`
+	Platform       string               `json:"platform"`
+	Event          string               `json:"event"`
+	CanonicalEvent string               `json:"canonicalEvent,omitempty"`
+	Outcome        string               `json:"outcome"`
+	Reason         string               `json:"reason,omitempty"`
+	SyncRoute      string               `json:"syncRoute,omitempty"` // the path of the matching sync route
+	Plugins        []*simulatedDelivery `json:"plugins,omitempty"`
+}
+
+// simulatedDelivery is the message a plugin would receive, the rules selecting the
+// plugin are the paths of them in the configmap.
+type simulatedDelivery struct {
+	Plugin        string          `json:"plugin"`
+	Endpoint      string          `json:"endpoint"`
+	Bindings      []string        `json:"bindings"`
+	Events        string          `json:"events"` // the matching event rule
+	Notes         []string        `json:"notes,omitempty"`
+	Headers       http.Header     `json:"headers,omitempty"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+	PayloadBase64 []byte          `json:"payloadBase64,omitempty"`
+	Error         string          `json:"error,omitempty"`
+}
+
+// runSimulate prints the plugins which would receive a webhook, nothing is sent:
+//
+//	robot-universal-access simulate [-platform PLATFORM] -org ORG -repo REPO -event EVENT CONFIG_FILE
+//	robot-universal-access simulate [-platform PLATFORM] -payload FILE -event EVENT [-header 'Name: value'] CONFIG_FILE
+//
+// The platform is the one of the event header given by -header if it is not set,
+// and defaults to GitCode.
+func runSimulate(args []string, stdout io.Writer) int {
+	fs := flag.NewFlagSet(simulateCommand, flag.ContinueOnError)
+	org := fs.String("org", "", "the org of the webhook")
+	repo := fs.String("repo", "", "the repository of the webhook")
+	event := fs.String("event", "", "the event type of the webhook, canonical or raw, eg \"Note Hook\"")
+	payload := fs.String("payload", "", "the recorded webhook body, the org and the repository are parsed from it")
+	format := fs.String("format", outputText, "the output format, text or json")
+	platform := fs.String("platform", "", "the platform sending the webhook, gitcode, gitee or github")
+	header := headerFlags{}
+	fs.Var(header, "header", "a header of the recorded webhook, 'Name: value', repeatable")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(fs.Output(), "usage: "+simulateCommand+" [-platform PLATFORM] -org ORG -repo REPO -event EVENT CONFIG_FILE")
+		_, _ = fmt.Fprintln(fs.Output(), "       "+simulateCommand+" [-platform PLATFORM] -payload FILE -event EVENT [-header 'Name: value'] CONFIG_FILE")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	_, knownPlatform := platformEventHeaders[*platform]
+	if fs.NArg() != 1 || *event == "" || (*payload == "") == (*org == "" || *repo == "") ||
+		(*format != outputText && *format != outputJSON) || (*platform != "" && !knownPlatform) {
+		fs.Usage()
+		return 2
+	}
+
+	cfg := &configuration{}
+	if err := commonutils.LoadFromYaml(fs.Arg(0), cfg); err != nil {
+		_, _ = fmt.Fprintln(fs.Output(), "failed to load the configmap: "+err.Error())
+		return 1
+	}
+	if err := cfg.Validate(); err != nil {
+		_, _ = fmt.Fprintln(fs.Output(), "the configmap is invalid, see the "+lintCommand+" subcommand: "+err.Error())
+		return 1
+	}
+
+	h := http.Header(header)
+	if *platform == "" {
+		if *platform = platformOf(h); *platform == "" {
+			*platform = platformGitCode
+		}
+	}
+	h.Set(platformEventHeaders[*platform], *event)
+	var evt *client.GenericEvent
+	raw := newMemorySpool(nil)
+	if *payload != "" {
+		data, err := os.ReadFile(*payload)
+		if err != nil {
+			_, _ = fmt.Fprintln(fs.Output(), "failed to read the payload: "+err.Error())
+			return 1
+		}
+		if evt, err = parseWebhook(h, data); err != nil {
+			_, _ = fmt.Fprintln(fs.Output(), "invalid payload: "+err.Error())
+			return 1
+		}
+		raw = newMemorySpool(data)
+	} else {
+		evt = &client.GenericEvent{EventType: event, Org: org, Repo: repo}
+	}
+
+	s := simulateWebhook(cfg, evt, h, raw)
+	if *format == outputJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(s)
+	} else {
+		s.print(stdout)
+	}
+
+	return 0
+}
+
+// parseWebhook parses the recorded webhook as the gateway does.
+func parseWebhook(h http.Header, data []byte) (*client.GenericEvent, error) {
+	r, err := http.NewRequest(http.MethodPost, "/", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	r.Header = h.Clone()
+	if r.Header.Get(headerContentType) == "" {
+		r.Header.Set(headerContentType, contentTypeJSON)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	evt := client.NewGenericEvent(httptest.NewRecorder(), r, logrus.NewEntry(logger))
+	if evt.GetMetaPayload() == nil {
+		return nil, errors.New("the body is not a webhook of the event")
+	}
+	if utils.GetString(evt.Org) == "" || utils.GetString(evt.Repo) == "" {
+		return nil, errors.New("the body does not contain the org or the repository")
+	}
+
+	return evt, nil
+}
+
+// simulateWebhook selects the plugins of the webhook and encodes their messages as
+// the gateway does, the header h is the one of the webhook.
+func simulateWebhook(c *configuration, evt *client.GenericEvent, h http.Header, raw *spool) *simulation {
+	org, repo, event := utils.GetString(evt.Org), utils.GetString(evt.Repo), utils.GetString(evt.EventType)
+	s := &simulation{Org: org, Repo: repo, Platform: platformOf(h), Event: event}
+	events := []string{event}
+	if payload, err := raw.bytes(); err == nil {
+		events = routedEventTypes(event, payload)
+	}
+	if canonical, ok := canonicalOf(events); ok {
+		s.CanonicalEvent = canonical
+		h.Set(headerRobotEventType, canonical)
+	}
+
+	plugins := c.GetPlugins(org, repo, events...)
+	if len(plugins) == 0 {
+		s.Outcome, s.Reason = outcomeDropped, c.dropReason(org, repo, events...)
+		return s
+	}
+
+	s.Outcome = outcomeAccepted
+	if route := c.ConfigItems.syncRouteOf(org, repo, events...); route != nil {
+		for i := range c.ConfigItems.SyncRoutes {
+			if &c.ConfigItems.SyncRoutes[i] == route {
+				s.SyncRoute = "access.sync_routes[" + strconv.Itoa(i) + "]"
+			}
+		}
+	}
+
+	d := newDelivery(newDeliveryID(), evt, h, raw)
+	d.redact = c.ConfigItems.Redact
+	for _, p := range plugins {
+		s.Plugins = append(s.Plugins, simulateDelivery(c, d, p, events))
+	}
+
+	return s
+}
+
+// simulateDelivery encodes the message of the plugin, the events are the ones the webhook is routed by.
+func simulateDelivery(c *configuration, d *delivery, p *pluginConfig, events []string) *simulatedDelivery {
+	org, repo := utils.GetString(d.event.Org), utils.GetString(d.event.Repo)
+	sd := &simulatedDelivery{Plugin: p.Name, Endpoint: p.location(), Notes: deliveryNotes(p, events)}
+
+	for _, key := range []string{org, org + "/" + repo} {
+		if slices.Contains(c.ConfigItems.RepoPlugins[key], p.Name) {
+			sd.Bindings = append(sd.Bindings, "access.repo_plugins."+key)
+		}
+	}
+	path := ""
+	for i := range c.ConfigItems.Plugins {
+		if &c.ConfigItems.Plugins[i] == p {
+			path = "access.plugins[" + strconv.Itoa(i) + "]"
+		}
+	}
+	for i, e := range p.Events {
+		if eventMatches([]string{e}, events...) {
+			sd.Events = path + ".events[" + strconv.Itoa(i) + "] " + strconv.Quote(e)
+			break
+		}
+	}
+
+	msg, err := d.encode(p)
+	if err != nil {
+		sd.Error = err.Error()
+		return sd
+	}
+	sd.Headers = msg.header
+	body, err := msg.payload()
+	if err != nil {
+		sd.Error = err.Error()
+		return sd
+	}
+	if json.Valid(body) {
+		sd.Payload = body
+	} else {
+		sd.PayloadBase64 = body
+	}
+
+	return sd
+}
+
+// deliveryNotes describes how the message would be delivered besides being sent at once.
+func deliveryNotes(p *pluginConfig, events []string) []string {
+	var notes []string
+	if p.Mode == modePull {
+		notes = append(notes, "buffered until the plugin pulls it")
+	}
+	if p.Ordering != "" {
+		notes = append(notes, "ordered by "+p.Ordering)
+	}
+	if p.Debounce != nil && p.Debounce.applies(events...) {
+		notes = append(notes, "debounced for "+time.Duration(p.Debounce.Window).String())
+	}
+	if p.RateLimit != nil {
+		notes = append(notes, "rate limited to "+strconv.FormatFloat(p.RateLimit.Rate, 'f', -1, 64)+"/s")
+	}
+	if p.Batch != nil {
+		notes = append(notes, "batched")
+	}
+	if p.Compression != "" {
+		notes = append(notes, "compressed by "+p.Compression)
+	}
+	if p.HealthCheck != nil {
+		notes = append(notes, "held while the plugin is unhealthy")
+	}
+
+	return notes
+}
+
+func (s *simulation) print(w io.Writer) {
+	event := strconv.Quote(s.Event)
+	if s.CanonicalEvent != "" && s.CanonicalEvent != s.Event {
+		event += " (" + s.CanonicalEvent + ")"
+	}
+	_, _ = fmt.Fprintf(w, "webhook %s/%s %s: %s", s.Org, s.Repo, event, s.Outcome)
+	if s.Reason != "" {
+		_, _ = fmt.Fprintf(w, ", %s\n", s.Reason)
+		return
+	}
+	_, _ = fmt.Fprintf(w, " by %d plugin(s)\n", len(s.Plugins))
+	if s.SyncRoute != "" {
+		_, _ = fmt.Fprintln(w, "dispatched synchronously by "+s.SyncRoute)
+	}
+
+	for _, p := range s.Plugins {
+		_, _ = fmt.Fprintln(w)
+		_, _ = fmt.Fprintln(w, "plugin "+p.Plugin)
+		_, _ = fmt.Fprintln(w, "  endpoint: "+p.Endpoint)
+		_, _ = fmt.Fprintln(w, "  bindings: "+strings.Join(p.Bindings, ", "))
+		_, _ = fmt.Fprintln(w, "  events:   "+p.Events)
+		for _, note := range p.Notes {
+			_, _ = fmt.Fprintln(w, "  note:     "+note)
+		}
+		if p.Error != "" {
+			_, _ = fmt.Fprintln(w, "  error:    "+p.Error)
+			continue
+		}
+
+		_, _ = fmt.Fprintln(w, "  headers:")
+		names := make([]string, 0, len(p.Headers))
+		for name := range p.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, v := range p.Headers[name] {
+				_, _ = fmt.Fprintln(w, "    "+name+": "+v)
+			}
+		}
+
+		_, _ = fmt.Fprintln(w, "  body:")
+		body := bytes.Buffer{}
+		switch {
+		case len(p.PayloadBase64) > 0:
+			body.WriteString("(base64) " + base64.StdEncoding.EncodeToString(p.PayloadBase64))
+		case json.Indent(&body, p.Payload, "    ", "  ") != nil:
+			body.Reset()
+			body.Write(p.Payload)
+		}
+		_, _ = fmt.Fprintln(w, "    "+body.String())
+	}
+}
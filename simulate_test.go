@@ -0,0 +1,147 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSimulateWebhook(t *testing.T) {
+	data, _ := os.ReadFile(findTestdata(t, "pr_note.json"))
+	h := headerFlags{}
+	assert.Equal(t, nil, h.Set(headerEventGUID+": "+headerEventGUIDValue))
+	assert.Equal(t, nil, h.Set(headerEventType+":"+headerEventTypeValue))
+	assert.NotEqual(t, nil, h.Set(headerEventType))
+	evt, err := parseWebhook(http.Header(h), data)
+	assert.Equal(t, nil, err)
+
+	c := &configuration{ConfigItems: accessConfig{
+		RepoPlugins: map[string][]string{"ibforuorg": {"serv1", "serv2"}, "ibforuorg/test1": {"serv3"}},
+		Plugins: []pluginConfig{
+			{Name: "serv1", Endpoint: "http://localhost:8080/hook", Events: []string{eventPullRequest}},
+			{Name: "serv2", Endpoint: "http://localhost:8081/hook", Events: []string{eventPush, headerEventTypeValue},
+				Payload: payloadRaw, Debounce: &debounceConfig{Window: duration(5 * time.Second)}},
+			{Name: "serv3", Endpoint: "http://localhost:8082/hook", Events: []string{eventComment}},
+		},
+		SyncRoutes: []syncRoute{{Repos: []string{"other"}}, {Events: []string{eventComment}}},
+	}}
+
+	s := simulateWebhook(c, evt, http.Header(h), newMemorySpool(data))
+	assert.Equal(t, outcomeAccepted, s.Outcome)
+	assert.Equal(t, eventComment, s.CanonicalEvent)
+	assert.Equal(t, "access.sync_routes[1]", s.SyncRoute)
+	assert.Equal(t, 2, len(s.Plugins))
+
+	serv2 := s.Plugins[0]
+	assert.Equal(t, "serv2", serv2.Plugin)
+	assert.Equal(t, "http://localhost:8081/hook", serv2.Endpoint)
+	assert.Equal(t, []string{"access.repo_plugins.ibforuorg"}, serv2.Bindings)
+	assert.Equal(t, `access.plugins[1].events[1] "Note Hook"`, serv2.Events)
+	assert.Equal(t, []string{"debounced for 5s"}, serv2.Notes)
+	assert.Equal(t, headerEventGUIDValue, serv2.Headers.Get(headerEventGUID))
+	assert.Equal(t, eventComment, serv2.Headers.Get(headerRobotEventType))
+	assert.Equal(t, json.RawMessage(data), serv2.Payload)
+
+	serv3 := s.Plugins[1]
+	assert.Equal(t, []string{"access.repo_plugins.ibforuorg/test1"}, serv3.Bindings)
+	assert.Equal(t, `access.plugins[2].events[0] "comment"`, serv3.Events)
+	assert.Contains(t, string(serv3.Payload), `"comment":"/lgtm\n/approve"`)
+}
+
+func TestRunSimulate(t *testing.T) {
+	config := findTestdata(t, "config.yaml")
+	testCases := []struct {
+		no      string
+		args    []string
+		code    int
+		outcome string
+		reason  string
+		plugins int
+	}{
+		{"case0", []string{"-format", outputJSON, "-org", "ibforuorg", "-repo", "test1", "-event", eventComment, config}, 0, outcomeAccepted, "", 2},
+		{"case1", []string{"-format", outputJSON, "-org", "ibforuorg", "-repo", "test1", "-event", eventRelease, config}, 0, outcomeDropped, reasonNoSubscriber, 0},
+		{"case2", []string{"-format", outputJSON, "-org", "other", "-repo", "test1", "-event", eventComment, config}, 0, outcomeDropped, reasonNoBinding, 0},
+		{"case3", []string{"-format", outputJSON, "-payload", findTestdata(t, "pr_note.json"), "-event", headerEventTypeValue, config}, 0, outcomeAccepted, "", 2},
+		{"case4", []string{"-org", "ibforuorg", "-repo", "test1", config}, 2, "", "", 0},
+		{"case5", []string{"-org", "ibforuorg", "-repo", "test1", "-event", eventComment, "-payload", "x.json", config}, 2, "", "", 0},
+		{"case6", []string{"-org", "ibforuorg", "-repo", "test1", "-event", eventComment, findTestdata(t, "config23.yaml")}, 1, "", "", 0},
+		{"case7", []string{"-platform", "gitlab", "-org", "ibforuorg", "-repo", "test1", "-event", eventComment, config}, 2, "", "", 0},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			out := bytes.Buffer{}
+			assert.Equal(t, testCases[i].code, runSimulate(testCases[i].args, &out))
+			if testCases[i].code != 0 {
+				return
+			}
+
+			s := simulation{}
+			assert.Equal(t, nil, json.Unmarshal(out.Bytes(), &s))
+			assert.Equal(t, testCases[i].outcome, s.Outcome)
+			assert.Equal(t, testCases[i].reason, s.Reason)
+			assert.Equal(t, testCases[i].plugins, len(s.Plugins))
+		})
+	}
+}
+
+func TestRunSimulatePlatform(t *testing.T) {
+	config := findTestdata(t, "config.yaml")
+	testCases := []struct {
+		no     string
+		args   []string
+		header string
+	}{
+		{"case0", []string{"-event", "issue_comment"}, "X-GitCode-Event"},
+		{"case1", []string{"-platform", platformGitHub, "-event", "issue_comment"}, "X-GitHub-Event"},
+		{"case2", []string{"-header", "X-Gitee-Event: Note Hook", "-event", "Note Hook"}, "X-Gitee-Event"},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			out := bytes.Buffer{}
+			args := append([]string{"-format", outputJSON, "-org", "ibforuorg", "-repo", "test1"}, testCases[i].args...)
+			assert.Equal(t, 0, runSimulate(append(args, config), &out))
+
+			s := simulation{}
+			assert.Equal(t, nil, json.Unmarshal(out.Bytes(), &s))
+			assert.Equal(t, 2, len(s.Plugins))
+			// the event header is the one of the platform only
+			for _, p := range s.Plugins {
+				assert.NotEqual(t, "", p.Headers.Get(testCases[i].header))
+				for _, h := range platformEventHeaders {
+					if h != testCases[i].header {
+						assert.Equal(t, "", p.Headers.Get(h))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRunSimulateText(t *testing.T) {
+	out := bytes.Buffer{}
+	assert.Equal(t, 0, runSimulate([]string{"-org", "ibforuorg", "-repo", "test1", "-event", headerEventTypeValue,
+		findTestdata(t, "config.yaml")}, &out))
+
+	lines := strings.Split(out.String(), "\n")
+	assert.Equal(t, `webhook ibforuorg/test1 "Note Hook" (comment): accepted by 2 plugin(s)`, lines[0])
+	assert.Contains(t, out.String(), "plugin service-name1\n  endpoint: http://localhost:7000/gitcode-hook\n")
+	assert.Contains(t, out.String(), "    Robot-Event-Type: comment\n")
+}
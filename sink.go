@@ -0,0 +1,329 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// retryCount is the number of retries after a failed delivery, whatever the sink is.
+	retryCount    = 2
+	retryWaitTime = 100 * time.Millisecond
+)
+
+// sink delivers the messages to the endpoint of a plugin.
+type sink interface {
+	send(msg *message) error
+	close() error
+}
+
+type sinkFactory func(bot *robot, p *pluginConfig, u *url.URL) (sink, error)
+
+// sinkFactories creates the sink by the scheme of the plugin's endpoint.
+var sinkFactories = map[string]sinkFactory{
+	"http":  newHTTPSink,
+	"https": newHTTPSink,
+	"kafka": newBrokerSink(newKafkaPublisher),
+	"nats":  newBrokerSink(newNATSPublisher),
+	"amqp":  newBrokerSink(newAMQPPublisher),
+	"amqps": newBrokerSink(newAMQPPublisher),
+	"grpc":  newGRPCSink(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	"grpcs": newGRPCSink(grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12}))),
+}
+
+func validateEndpoint(endpoint string) error {
+	u, err := url.ParseRequestURI(endpoint)
+	if err != nil {
+		return errors.New(endpoint + " not a valid url")
+	}
+
+	if _, ok := sinkFactories[u.Scheme]; !ok {
+		return errors.New(u.Redacted() + " has an unsupported scheme")
+	}
+
+	if brokerSchemes.Has(u.Scheme) && topicOf(u) == "" {
+		return errors.New(u.Redacted() + " missing the topic")
+	}
+
+	return nil
+}
+
+// withRetry calls fn until it succeeds or the retries are exhausted.
+func withRetry(fn func() error) (err error) {
+	for i := 0; ; i++ {
+		if err = fn(); err == nil || i >= retryCount {
+			return
+		}
+		time.Sleep(retryWaitTime)
+	}
+}
+
+// sinkOf returns the sink of the plugin, it is created at the first delivery.
+func (bot *robot) sinkOf(p *pluginConfig) (sink, error) {
+	bot.sinkLock.Lock()
+	defer bot.sinkLock.Unlock()
+
+	if p.Mode == modePull {
+		if s, ok := bot.subscriptions.items[p.Name]; ok {
+			return s, nil
+		}
+		return nil, errors.New("no subscription of plugin " + p.Name)
+	}
+
+	if s, ok := bot.sinks[p.Name]; ok {
+		return s, nil
+	}
+
+	s, err := bot.newPluginSink(p)
+	if err != nil {
+		return nil, err
+	}
+	hs, ok := s.(*httpSink)
+	switch {
+	case p.Batch != nil && ok:
+		// the batches are rate limited and held instead of the messages
+		s = newBatchSink(hs, p, bot.health[p.Name])
+	case p.RateLimit != nil || p.HealthCheck != nil:
+		s = newQueuedSink(s, p, bot.health[p.Name])
+	}
+	bot.sinks[p.Name] = s
+
+	return s, nil
+}
+
+// newPluginSink creates the sink of the plugin's endpoints, which are balanced if
+// there are many or they are discovered.
+func (bot *robot) newPluginSink(p *pluginConfig) (sink, error) {
+	if p.Discovery != nil {
+		return newDiscoveredSink(bot, p)
+	}
+
+	sinks, uris, err := newEndpointSinks(bot, p)
+	if err != nil {
+		return nil, err
+	}
+	if len(sinks) > 1 {
+		return newBalancedSink(p, sinks, uris), nil
+	}
+
+	return sinks[0], nil
+}
+
+// newEndpointSink creates the sink by the scheme of the endpoint.
+func newEndpointSink(bot *robot, p *pluginConfig, endpoint string) (sink, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	factory, ok := sinkFactories[u.Scheme]
+	if !ok {
+		return nil, errors.New(u.Redacted() + " has an unsupported scheme")
+	}
+
+	return factory(bot, p, u)
+}
+
+func (bot *robot) closeSinks() {
+	bot.sinkLock.Lock()
+	defer bot.sinkLock.Unlock()
+
+	for name, s := range bot.sinks {
+		if err := s.close(); err != nil {
+			bot.log.WithError(err).Error("failed to close the sink of " + name)
+		}
+		delete(bot.sinks, name)
+	}
+}
+
+// statusError is the error status code responded by a plugin.
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("the plugin responded with status %d", e.code)
+}
+
+// statusOf returns the status code of the error responded by a plugin, or 0.
+func statusOf(err error) int {
+	var e *statusError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return 0
+}
+
+// sendStatus sends the message, and returns the status code responded by the plugin
+// if the sink knows it.
+func sendStatus(s sink, msg *message) (int, error) {
+	if v, ok := s.(interface {
+		sendStatus(*message) (int, error)
+	}); ok {
+		return v.sendStatus(msg)
+	}
+
+	err := s.send(msg)
+	return statusOf(err), err
+}
+
+// httpSink posts the messages to a http endpoint.
+type httpSink struct {
+	client *resty.Client
+	uri    string
+
+	// encoding is the content encoding accepted by the plugin, it is learned from
+	// the responses if auto is set.
+	lock     sync.Mutex
+	encoding string
+	auto     bool
+}
+
+func newHTTPSink(bot *robot, p *pluginConfig, u *url.URL) (sink, error) {
+	s := &httpSink{client: bot.client, uri: u.String(), encoding: p.Compression}
+	if s.encoding == "" || s.encoding == compressionAuto {
+		s.encoding, s.auto = encodingIdentity, s.encoding == compressionAuto
+	}
+
+	return s, nil
+}
+
+func (s *httpSink) send(msg *message) error {
+	_, err := s.sendStatus(msg)
+	return err
+}
+
+func (s *httpSink) sendStatus(msg *message) (int, error) {
+	resp, err := s.deliver(msg)
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.IsError() {
+		return resp.StatusCode(), &statusError{code: resp.StatusCode()}
+	}
+
+	return resp.StatusCode(), nil
+}
+
+// deliver posts the message by the encoding accepted by the plugin, and returns the response.
+func (s *httpSink) deliver(msg *message) (*resty.Response, error) {
+	encoding := s.acceptedEncoding()
+	resp, err := s.post(msg, encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	// the plugin does not accept the encoding, the body is resent by the one it
+	// advertises (RFC 7694)
+	if resp.StatusCode() == http.StatusUnsupportedMediaType && encoding != encodingIdentity {
+		s.remember(negotiateEncoding(resp.Header().Get(headerAcceptEncoding)))
+		return s.post(msg, s.acceptedEncoding())
+	}
+
+	if v := resp.Header().Get(headerAcceptEncoding); s.auto && v != "" {
+		s.remember(negotiateEncoding(v))
+	}
+
+	return resp, nil
+}
+
+func (s *httpSink) post(msg *message, encoding string) (*resty.Response, error) {
+	req := s.client.R()
+	req.Header = msg.header.Clone()
+	if msg.stream != nil && encoding == encodingIdentity {
+		req.SetBody(&spoolReader{s: msg.stream})
+	} else {
+		payload, err := msg.payload()
+		if err != nil {
+			return nil, err
+		}
+		body, err := compress(encoding, payload)
+		if err != nil {
+			return nil, err
+		}
+		if encoding != encodingIdentity {
+			req.Header.Set(headerContentEncoding, encoding)
+		}
+		req.SetBody(body)
+	}
+
+	resp, err := req.Post(s.uri)
+	if err != nil {
+		return nil, err
+	}
+	if resp.RawBody() != nil {
+		_, _ = io.Copy(io.Discard, resp.RawBody())
+	}
+
+	return resp, nil
+}
+
+func (s *httpSink) acceptedEncoding() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.encoding
+}
+
+func (s *httpSink) remember(encoding string) {
+	s.lock.Lock()
+	s.encoding = encoding
+	s.lock.Unlock()
+}
+
+func (s *httpSink) close() error {
+	return nil
+}
+
+// location describes where the events of the plugin go, it is safe to be logged.
+func (p *pluginConfig) location() string {
+	if p.Mode == modePull {
+		return subscriptionPathPrefix + p.Name
+	}
+
+	if p.Discovery != nil {
+		return p.Discovery.Resolver + ":" + p.Discovery.Name
+	}
+
+	endpoints := p.endpoints()
+	uris := make([]string, len(endpoints))
+	for i := range endpoints {
+		uris[i] = redactedURL(endpoints[i])
+	}
+
+	return strings.Join(uris, ",")
+}
+
+// redactedURL hides the password of the endpoint, so it can be logged.
+func redactedURL(endpoint string) string {
+	if u, err := url.Parse(endpoint); err == nil {
+		return u.Redacted()
+	}
+
+	return endpoint
+}
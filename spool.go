@@ -0,0 +1,144 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+const (
+	defaultMaxBodySize    = 25 << 20
+	defaultSpillThreshold = 1 << 20
+)
+
+var errBodyTooLarge = errors.New("the request body is too large")
+
+// spool keeps the original body of a webhook during the fan-out. A small body is
+// kept in memory, and a large one is written to a temporary file, which is streamed
+// to the plugins requiring the raw payload.
+type spool struct {
+	data []byte
+	file string
+	size int64
+	refs atomic.Int32 // the holders of the file, it is removed once they are done
+}
+
+func newMemorySpool(data []byte) *spool {
+	return &spool{data: data, size: int64(len(data))}
+}
+
+// newSpool reads r until EOF, it fails with errBodyTooLarge once more than limit
+// bytes are read. The body is spilled to a file in dir if it exceeds the threshold.
+func newSpool(r io.Reader, limit, threshold int64, dir string) (*spool, error) {
+	buf := bytes.Buffer{}
+	n, err := io.Copy(&buf, io.LimitReader(r, min(limit, threshold)+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > limit {
+		return nil, errBodyTooLarge
+	}
+	if n <= threshold {
+		return newMemorySpool(buf.Bytes()), nil
+	}
+
+	f, err := os.CreateTemp(dir, "robot-access-*.body")
+	if err != nil {
+		return nil, err
+	}
+	s := &spool{file: f.Name()}
+	s.refs.Store(1)
+
+	n, err = io.Copy(f, io.MultiReader(&buf, io.LimitReader(r, limit-n+1)))
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil && n > limit {
+		err = errBodyTooLarge
+	}
+	if err != nil {
+		s.remove()
+		return nil, err
+	}
+	s.size = n
+
+	return s, nil
+}
+
+func (s *spool) spilled() bool {
+	return s.file != ""
+}
+
+func (s *spool) open() (io.ReadCloser, error) {
+	if !s.spilled() {
+		return io.NopCloser(bytes.NewReader(s.data)), nil
+	}
+
+	return os.Open(s.file)
+}
+
+func (s *spool) bytes() ([]byte, error) {
+	if !s.spilled() {
+		return s.data, nil
+	}
+
+	return os.ReadFile(s.file)
+}
+
+// retain keeps the temporary file until remove is called once more.
+func (s *spool) retain() {
+	s.refs.Add(1)
+}
+
+// remove deletes the temporary file, it must be called once the delivery is done,
+// and once by each holder retaining it.
+func (s *spool) remove() {
+	if s.spilled() && s.refs.Add(-1) == 0 {
+		_ = os.Remove(s.file)
+	}
+}
+
+// spoolReader streams the file of the spool. It is opened again once it is closed,
+// so that it is resent by the retries.
+type spoolReader struct {
+	s *spool
+	r io.ReadCloser
+}
+
+func (r *spoolReader) Read(p []byte) (int, error) {
+	if r.r == nil {
+		f, err := r.s.open()
+		if err != nil {
+			return 0, err
+		}
+		r.r = f
+	}
+
+	return r.r.Read(p)
+}
+
+func (r *spoolReader) Close() error {
+	if r.r == nil {
+		return nil
+	}
+
+	err := r.r.Close()
+	r.r = nil
+
+	return err
+}
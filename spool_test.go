@@ -0,0 +1,155 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestNewSpool(t *testing.T) {
+	testCases := []struct {
+		no        string
+		size      int
+		limit     int64
+		threshold int64
+		out       []any
+	}{
+		{"case0", 10, 100, 50, []any{false, nil}},
+		{"case1", 50, 100, 50, []any{false, nil}},
+		{"case2", 51, 100, 50, []any{true, nil}},
+		{"case3", 100, 100, 50, []any{true, nil}},
+		{"case4", 101, 100, 50, []any{false, errBodyTooLarge}},
+		{"case5", 30, 20, 50, []any{false, errBodyTooLarge}},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			dir := t.TempDir()
+			data := bytes.Repeat([]byte("x"), testCases[i].size)
+
+			s, err := newSpool(bytes.NewReader(data), testCases[i].limit, testCases[i].threshold, dir)
+			assert.Equal(t, testCases[i].out[1], err)
+			if err != nil {
+				files, _ := os.ReadDir(dir)
+				assert.Equal(t, 0, len(files))
+				return
+			}
+
+			assert.Equal(t, testCases[i].out[0], s.spilled())
+			assert.Equal(t, int64(len(data)), s.size)
+			b, err := s.bytes()
+			assert.Equal(t, nil, err)
+			assert.Equal(t, data, b)
+
+			s.remove()
+			files, _ := os.ReadDir(dir)
+			assert.Equal(t, 0, len(files))
+		})
+	}
+}
+
+func TestServeHTTPBodyLimits(t *testing.T) {
+	dir := t.TempDir()
+	data, _ := os.ReadFile(findTestdata(t, "pr_note.json"))
+	size := byteSize(len(data))
+	gzipped, _ := compress(encodingGzip, data)
+
+	testCases := []struct {
+		no       string
+		limits   limitsConfig
+		encoding string
+		out      []any
+	}{
+		{"case0", limitsConfig{MaxBodySize: size, SpillThreshold: 64, SpillDir: dir}, "", []any{http.StatusOK, 0.0, 0.0, 0.0}},
+		{"case1", limitsConfig{MaxBodySize: size - 1}, "", []any{http.StatusRequestEntityTooLarge, 1.0, 0.0, 0.0}},
+		{"case2", limitsConfig{MaxBodySize: size - 1}, encodingGzip, []any{http.StatusRequestEntityTooLarge, 1.0, 0.0, 0.0}},
+		{"case3", limitsConfig{MaxBodySize: size - 1, OrgMaxBodySize: map[string]byteSize{"ibforuorg": size}}, "", []any{http.StatusOK, 0.0, 0.0, 0.0}},
+		{"case4", limitsConfig{OrgMaxBodySize: map[string]byteSize{"ibforuorg": size - 1, "other": size}}, "", []any{http.StatusRequestEntityTooLarge, 0.0, 1.0, 0.0}},
+		{"case5", limitsConfig{MaxBodySize: size - 1, OrgMaxBodySize: map[string]byteSize{"other": size}}, "", []any{http.StatusRequestEntityTooLarge, 0.0, 0.0, 1.0}},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			rejectedRequests.Reset()
+			bot := newRobot(&configuration{ConfigItems: accessConfig{Limits: testCases[i].limits}})
+
+			body := data
+			if testCases[i].encoding != "" {
+				body = gzipped
+			}
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/limits", bytes.NewReader(body))
+			req.Header.Set(headerContentTypeName, headerContentTypeJsonValue)
+			req.Header.Set(headerContentEncoding, testCases[i].encoding)
+			req.Header.Set(headerEventType, headerEventTypeValue)
+			req.Header.Set(headerEventGUID, headerEventGUIDValue)
+			bot.ServeHTTP(w, req)
+
+			assert.Equal(t, testCases[i].out[0], w.Result().StatusCode)
+			assert.Equal(t, testCases[i].out[1], testutil.ToFloat64(rejectedRequests.WithLabelValues(rejectBodyTooLarge, "")))
+			assert.Equal(t, testCases[i].out[2], testutil.ToFloat64(rejectedRequests.WithLabelValues(rejectBodyTooLarge, "ibforuorg")))
+			// the org not configured is not a label of its own
+			assert.Equal(t, testCases[i].out[3], testutil.ToFloat64(rejectedRequests.WithLabelValues(rejectBodyTooLarge, unknownOrg)))
+
+			// the spilled body is removed once the webhook is handled
+			files, _ := os.ReadDir(dir)
+			assert.Equal(t, 0, len(files))
+		})
+	}
+}
+
+func TestServeHTTPSpilledStream(t *testing.T) {
+	dir := t.TempDir()
+	data, _ := os.ReadFile(findTestdata(t, "pr_note.json"))
+
+	// the connection is closed the first time, so the file is streamed once more by the retry
+	bodies := make(chan []byte, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+		if len(bodies) == 1 {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			_ = conn.Close()
+		}
+	}))
+	defer server.Close()
+
+	bot := newRobot(&configuration{ConfigItems: accessConfig{
+		RepoPlugins: map[string][]string{"ibforuorg": {"serv1"}},
+		Plugins:     []pluginConfig{{Name: "serv1", Endpoint: server.URL, Payload: payloadRaw, Events: []string{eventComment}}},
+		Limits:      limitsConfig{SpillThreshold: 64, SpillDir: dir},
+	}})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/limits", bytes.NewReader(data))
+	req.Header.Set(headerContentTypeName, headerContentTypeJsonValue)
+	req.Header.Set(headerEventType, headerEventTypeValue)
+	req.Header.Set(headerEventGUID, headerEventGUIDValue)
+	bot.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	bot.wg.Wait()
+
+	assert.Equal(t, 2, len(bodies))
+	for len(bodies) > 0 {
+		assert.Equal(t, data, <-bodies)
+	}
+
+	// the spilled body is removed once it is sent
+	files, _ := os.ReadDir(dir)
+	assert.Equal(t, 0, len(files))
+}
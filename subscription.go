@@ -0,0 +1,361 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	modePush = "push"
+	modePull = "pull"
+
+	subscriptionPathPrefix = "/subscriptions/"
+
+	defaultAckTimeout     = 60 * time.Second
+	defaultBufferSize     = 1000
+	defaultPullMax        = 10
+	maxPullMax            = 100
+	defaultPullWait       = 30 * time.Second
+	maxPullWait           = 60 * time.Second
+	streamKeepAlivePeriod = 15 * time.Second
+)
+
+var errSubscriptionFull = errors.New("the buffer of the subscription is full")
+
+// pulledEvent is a buffered message pulled by the plugin. It must be acknowledged
+// by its ackID in time, otherwise it will be redelivered.
+type pulledEvent struct {
+	AckID         string            `json:"ackID"`
+	DeliveryID    string            `json:"deliveryID"`
+	Attempts      int               `json:"attempts"`
+	Headers       map[string]string `json:"headers"`
+	Payload       json.RawMessage   `json:"payload,omitempty"`
+	PayloadBase64 []byte            `json:"payloadBase64,omitempty"`
+
+	deadline time.Time
+	msg      *message // the original message, it is persisted on the shutdown
+}
+
+func newPulledEvent(ackID string, msg *message) *pulledEvent {
+	e := &pulledEvent{AckID: ackID, DeliveryID: msg.id, Headers: flattenHeader(msg.header), msg: msg}
+
+	if json.Valid(msg.body) {
+		e.Payload = msg.body
+	} else {
+		e.PayloadBase64 = msg.body
+	}
+
+	return e
+}
+
+// subscription buffers the events of a plugin in the pull mode.
+// It is the sink of the plugin.
+type subscription struct {
+	lock       sync.Mutex
+	token      string
+	ackTimeout time.Duration
+	bufferSize int
+	seq        uint64
+	queue      []*pulledEvent
+	inflight   map[string]*pulledEvent
+	arrived    chan struct{} // closed when there are new events
+}
+
+func newSubscription(cfg *subscriptionConfig) *subscription {
+	s := &subscription{
+		token:      cfg.Token,
+		ackTimeout: cfg.AckTimeout.or(defaultAckTimeout),
+		bufferSize: cfg.BufferSize,
+		inflight:   map[string]*pulledEvent{},
+		arrived:    make(chan struct{}),
+	}
+	if s.bufferSize <= 0 {
+		s.bufferSize = defaultBufferSize
+	}
+
+	return s
+}
+
+func (s *subscription) send(msg *message) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.queue)+len(s.inflight) >= s.bufferSize {
+		return errSubscriptionFull
+	}
+
+	s.seq++
+	s.queue = append(s.queue, newPulledEvent(strconv.FormatUint(s.seq, 10), msg))
+	s.notify()
+
+	return nil
+}
+
+func (s *subscription) close() error {
+	return nil
+}
+
+// pending returns the messages which are not acknowledged yet.
+func (s *subscription) pending() []*message {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	msgs := make([]*message, 0, len(s.queue)+len(s.inflight))
+	for _, e := range s.queue {
+		msgs = append(msgs, e.msg)
+	}
+	for _, e := range s.inflight {
+		msgs = append(msgs, e.msg)
+	}
+
+	return msgs
+}
+
+// notify wakes up the waiting pulls, the lock must be held.
+func (s *subscription) notify() {
+	close(s.arrived)
+	s.arrived = make(chan struct{})
+}
+
+// pull takes at most max events, it waits until there is any event or the ctx is done.
+func (s *subscription) pull(ctx context.Context, max int) []*pulledEvent {
+	for {
+		s.lock.Lock()
+		now := time.Now()
+		next := s.redeliver(now)
+		if n := len(s.queue); n > 0 {
+			if n > max {
+				n = max
+			}
+			events := make([]*pulledEvent, n)
+			for i, e := range s.queue[:n] {
+				e.Attempts++
+				e.deadline = now.Add(s.ackTimeout)
+				s.inflight[e.AckID] = e
+
+				v := *e
+				events[i] = &v
+			}
+			s.queue = s.queue[n:]
+			s.lock.Unlock()
+
+			return events
+		}
+		arrived := s.arrived
+		s.lock.Unlock()
+
+		timer := time.NewTimer(next)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-arrived:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// redeliver moves the events not acknowledged in time back to the queue, and returns
+// how long until the next one expires. The lock must be held.
+func (s *subscription) redeliver(now time.Time) time.Duration {
+	next := s.ackTimeout
+	var expired []*pulledEvent
+	for id, e := range s.inflight {
+		if d := e.deadline.Sub(now); d <= 0 {
+			expired = append(expired, e)
+			delete(s.inflight, id)
+		} else if d < next {
+			next = d
+		}
+	}
+
+	if len(expired) > 0 {
+		// the redelivered events go first
+		s.queue = append(expired, s.queue...)
+	}
+
+	return next
+}
+
+// ack removes the acknowledged events, and returns how many of them are found.
+func (s *subscription) ack(ids []string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	n := 0
+	for _, id := range ids {
+		if _, ok := s.inflight[id]; ok {
+			delete(s.inflight, id)
+			n++
+		}
+	}
+
+	return n
+}
+
+func (s *subscription) authenticate(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// subscriptions serves the subscription API for the plugins in the pull mode:
+//
+//	GET  /subscriptions/{plugin}/events?max=10&wait=30s  long-poll the events
+//	GET  /subscriptions/{plugin}/stream                  receive the events by Server-Sent Events
+//	POST /subscriptions/{plugin}/acks                    acknowledge the events, {"ackIDs": ["1"]}
+//
+// The plugin authenticates itself by the header "Authorization: Bearer {token}".
+type subscriptions struct {
+	items map[string]*subscription
+	log   *logrus.Entry
+}
+
+func newSubscriptions(c *configuration, log *logrus.Entry) *subscriptions {
+	s := &subscriptions{items: map[string]*subscription{}, log: log.WithField("module", "subscription")}
+	if c == nil {
+		return s
+	}
+
+	for i := range c.ConfigItems.Plugins {
+		if p := &c.ConfigItems.Plugins[i]; p.Mode == modePull {
+			s.items[p.Name] = newSubscription(&p.Subscription)
+		}
+	}
+
+	return s
+}
+
+func (s *subscriptions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, subscriptionPathPrefix), "/")
+	sub, ok := s.items[name]
+	if !ok {
+		http.Error(w, "404 Not Found: no subscription of plugin "+name, http.StatusNotFound)
+		return
+	}
+
+	if !sub.authenticate(r) {
+		http.Error(w, "401 Unauthorized: invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	logger := s.log.WithField("plugin", name)
+	switch {
+	case action == "events" && r.Method == http.MethodGet:
+		s.longPoll(w, r, sub)
+	case action == "stream" && r.Method == http.MethodGet:
+		s.stream(w, r, sub, logger)
+	case action == "acks" && r.Method == http.MethodPost:
+		var req struct {
+			AckIDs []string `json:"ackIDs"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "400 Bad Request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		n := sub.ack(req.AckIDs)
+		logger.Infof("%d of %d events are acknowledged", n, len(req.AckIDs))
+		writeJSON(w, http.StatusOK, map[string]int{"acknowledged": n})
+	default:
+		http.Error(w, "404 Not Found", http.StatusNotFound)
+	}
+}
+
+func (s *subscriptions) longPoll(w http.ResponseWriter, r *http.Request, sub *subscription) {
+	max, wait := defaultPullMax, defaultPullWait
+	if v := r.URL.Query().Get("max"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "400 Bad Request: invalid max", http.StatusBadRequest)
+			return
+		}
+		max = min(n, maxPullMax)
+	}
+	if v := r.URL.Query().Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			http.Error(w, "400 Bad Request: invalid wait", http.StatusBadRequest)
+			return
+		}
+		wait = min(d, maxPullWait)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), wait)
+	defer cancel()
+
+	events := sub.pull(ctx, max)
+	if events == nil {
+		events = []*pulledEvent{}
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+func (s *subscriptions) stream(w http.ResponseWriter, r *http.Request, sub *subscription, logger *logrus.Entry) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "500 Internal Server Error: streaming is unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for r.Context().Err() == nil {
+		ctx, cancel := context.WithTimeout(r.Context(), streamKeepAlivePeriod)
+		events := sub.pull(ctx, maxPullMax)
+		cancel()
+
+		if len(events) == 0 {
+			_, _ = fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		for _, e := range events {
+			data, err := json.Marshal(e)
+			if err != nil {
+				logger.WithError(err).Error("failed to encode the event " + e.AckID)
+				continue
+			}
+			_, _ = fmt.Fprintf(w, "id: %s\nevent: delivery\ndata: %s\n\n", e.AckID, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// flattenHeader keeps the first value of each header.
+func flattenHeader(h http.Header) map[string]string {
+	m := make(map[string]string, len(h))
+	for k := range h {
+		m[k] = h.Get(k)
+	}
+
+	return m
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set(headerContentType, contentTypeJSON)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
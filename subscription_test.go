@@ -0,0 +1,143 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const subscriptionToken = "secret"
+
+func newPullRobot(t *testing.T, ackTimeout time.Duration) *robot {
+	bot := newRobot(&configuration{ConfigItems: accessConfig{
+		RepoPlugins: map[string][]string{"ibforuorg": {"serv1"}},
+		Plugins: []pluginConfig{{
+			Name:         "serv1",
+			Events:       []string{eventComment},
+			Mode:         modePull,
+			Subscription: subscriptionConfig{Token: subscriptionToken, AckTimeout: duration(ackTimeout)},
+		}},
+	}})
+
+	data, _ := os.ReadFile(findTestdata(t, "pr_note.json"))
+	req, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/pull", bytes.NewReader(data))
+	req.Header.Set(headerUserAgent, headerUserAgentValue)
+	req.Header.Set(headerContentTypeName, headerContentTypeJsonValue)
+	req.Header.Set(headerEventType, headerEventTypeValue)
+	req.Header.Set(headerEventGUID, headerEventGUIDValue)
+	bot.ServeHTTP(httptest.NewRecorder(), req)
+	bot.wait()
+
+	return bot
+}
+
+func callSubscription(bot *robot, method, path, token string, body string) (int, []byte) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	bot.subscriptions.ServeHTTP(w, req)
+
+	return w.Code, w.Body.Bytes()
+}
+
+func TestSubscriptionLongPoll(t *testing.T) {
+	bot := newPullRobot(t, time.Minute)
+
+	code, _ := callSubscription(bot, http.MethodGet, "/subscriptions/serv1/events", "", "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = callSubscription(bot, http.MethodGet, "/subscriptions/serv2/events", subscriptionToken, "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, body := callSubscription(bot, http.MethodGet, "/subscriptions/serv1/events?max=5", subscriptionToken, "")
+	assert.Equal(t, http.StatusOK, code)
+	var events []pulledEvent
+	assert.Equal(t, nil, json.Unmarshal(body, &events))
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, "1", events[0].AckID)
+	assert.NotEqual(t, "", events[0].DeliveryID)
+	assert.Equal(t, events[0].DeliveryID, events[0].Headers[headerRobotDeliveryID])
+	assert.Equal(t, 1, events[0].Attempts)
+	assert.Equal(t, eventComment, events[0].Headers[headerRobotEventType])
+	assert.Contains(t, string(events[0].Payload), `"org":"ibforuorg"`)
+
+	// nothing left before the ack timeout
+	code, body = callSubscription(bot, http.MethodGet, "/subscriptions/serv1/events?wait=0s", subscriptionToken, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "[]\n", string(body))
+
+	code, body = callSubscription(bot, http.MethodPost, "/subscriptions/serv1/acks", subscriptionToken, `{"ackIDs": ["1", "2"]}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"acknowledged":1}`+"\n", string(body))
+	assert.Equal(t, 0, len(bot.subscriptions.items["serv1"].inflight))
+}
+
+func TestSubscriptionRedelivery(t *testing.T) {
+	bot := newPullRobot(t, 50*time.Millisecond)
+	sub := bot.subscriptions.items["serv1"]
+
+	_, body := callSubscription(bot, http.MethodGet, "/subscriptions/serv1/events", subscriptionToken, "")
+	var events []pulledEvent
+	assert.Equal(t, nil, json.Unmarshal(body, &events))
+	assert.Equal(t, 1, len(events))
+
+	// it is redelivered since the ack is missing
+	_, body = callSubscription(bot, http.MethodGet, "/subscriptions/serv1/events?wait=5s", subscriptionToken, "")
+	assert.Equal(t, nil, json.Unmarshal(body, &events))
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, "1", events[0].AckID)
+	assert.Equal(t, 2, events[0].Attempts)
+
+	assert.Equal(t, 1, sub.ack([]string{"1"}))
+	assert.Equal(t, 0, len(sub.queue)+len(sub.inflight))
+}
+
+func TestSubscriptionStream(t *testing.T) {
+	bot := newPullRobot(t, time.Minute)
+	server := httptest.NewServer(bot.subscriptions)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/subscriptions/serv1/stream", nil)
+	req.Header.Set("Authorization", "Bearer "+subscriptionToken)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Equal(t, nil, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get(headerContentType))
+
+	r := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := r.ReadString('\n')
+		assert.Equal(t, nil, err)
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	assert.Equal(t, "id: 1", lines[0])
+	assert.Equal(t, "event: delivery", lines[1])
+	data, ok := strings.CutPrefix(lines[2], "data: ")
+	assert.Equal(t, true, ok)
+	e := pulledEvent{}
+	assert.Equal(t, nil, json.Unmarshal([]byte(data), &e))
+	assert.NotEqual(t, "", e.DeliveryID)
+	assert.Equal(t, e.DeliveryID, e.Headers[headerRobotDeliveryID])
+}
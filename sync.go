@@ -0,0 +1,157 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultSyncTimeout = 10 * time.Second
+
+var errSyncTimeout = errors.New("the plugin did not respond before the deadline")
+
+// syncRoute dispatches the matching webhooks synchronously, the response is the
+// summary of the results of the plugins.
+type syncRoute struct {
+	// Repos are the orgs (eg "ibforuorg") or the repositories (eg "ibforuorg/test1")
+	// of the route. Defaults to all.
+	Repos []string `json:"repos,omitempty"`
+
+	// Events are the events of the route, either canonical or raw. Defaults to all.
+	Events []string `json:"events,omitempty"`
+
+	// Timeout is how long to wait for the plugins. Defaults to "10s".
+	Timeout duration `json:"timeout,omitempty"`
+
+	// Required are the plugins whose failures fail the webhook. Defaults to all the plugins.
+	Required []string `json:"required,omitempty"`
+}
+
+func (r *syncRoute) validate() error {
+	for _, repo := range r.Repos {
+		if repo == "" || strings.Count(repo, "/") > 1 {
+			return errors.New("sync route has an invalid repo " + repo)
+		}
+	}
+
+	return nil
+}
+
+func (r *syncRoute) matches(org, repo string, events []string) bool {
+	if len(r.Events) > 0 && !eventMatches(r.Events, events...) {
+		return false
+	}
+
+	return len(r.Repos) == 0 || slices.Contains(r.Repos, org) || slices.Contains(r.Repos, org+"/"+repo)
+}
+
+func (r *syncRoute) requires(plugin string) bool {
+	return len(r.Required) == 0 || slices.Contains(r.Required, plugin)
+}
+
+// syncRouteOf returns the first route matching the webhook, nil if it is dispatched asynchronously.
+func (a *accessConfig) syncRouteOf(org, repo string, events ...string) *syncRoute {
+	for i := range a.SyncRoutes {
+		if a.SyncRoutes[i].matches(org, repo, events) {
+			return &a.SyncRoutes[i]
+		}
+	}
+
+	return nil
+}
+
+// pluginResult is the result of delivering a webhook to a plugin.
+type pluginResult struct {
+	Plugin   string `json:"plugin"`
+	Required bool   `json:"required"`
+	Status   int    `json:"status,omitempty"` // the status code responded by a http plugin
+	Error    string `json:"error,omitempty"`
+
+	err error
+}
+
+// syncDispatcher delivers the webhook to all the plugins in parallel, and responds
+// the results once they are done or the deadline is exceeded. The status is 502 if
+// any required plugin failed, or 504 if any of them did not respond in time.
+func (bot *robot) syncDispatcher(w http.ResponseWriter, d *delivery, plugins []*pluginConfig, route *syncRoute) {
+	if wait := time.Until(d.notBefore); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	logger := bot.log.WithFields(d.loggingFields())
+	deadline := time.NewTimer(route.Timeout.or(defaultSyncTimeout))
+	defer deadline.Stop()
+
+	lock := sync.Mutex{}
+	results := make([]*pluginResult, len(plugins))
+	pending := make(chan struct{}, len(plugins))
+	dispatched := sync.WaitGroup{}
+	for i, p := range plugins {
+		results[i] = &pluginResult{Plugin: p.Name, Required: route.requires(p.Name), err: errSyncTimeout}
+
+		dispatched.Add(1)
+		go func(i int, p *pluginConfig) {
+			defer dispatched.Done()
+
+			bot.dispatch(d, p, logger, func(code int, err error) {
+				lock.Lock()
+				results[i].Status, results[i].err = code, err
+				lock.Unlock()
+				pending <- struct{}{}
+			})
+		}(i, p)
+	}
+
+	// the raw body is removed once it is encoded for all the plugins
+	go func() {
+		defer bot.wg.Done()
+
+		dispatched.Wait()
+		d.raw.remove()
+	}()
+
+	for n, timeout := 0, false; n < len(plugins) && !timeout; n++ {
+		select {
+		case <-pending:
+		case <-deadline.C:
+			timeout = true
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	status := http.StatusOK
+	for _, r := range results {
+		if r.err == nil {
+			continue
+		}
+		r.Error = r.err.Error()
+		if !r.Required {
+			continue
+		}
+		if errors.Is(r.err, errSyncTimeout) {
+			status = http.StatusGatewayTimeout
+		} else if status == http.StatusOK {
+			status = http.StatusBadGateway
+		}
+	}
+
+	respond(w, status, &webhookResponse{Outcome: outcomeAccepted, Plugins: pluginNames(plugins), Results: results})
+}
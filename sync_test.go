@@ -0,0 +1,118 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSyncRouteOf(t *testing.T) {
+	a := accessConfig{SyncRoutes: []syncRoute{
+		{Repos: []string{"ibforuorg/test1"}, Events: []string{eventPullRequest}},
+		{Repos: []string{"ibforuorg"}, Events: []string{eventComment}},
+	}}
+
+	assert.Equal(t, &a.SyncRoutes[0], a.syncRouteOf("ibforuorg", "test1", "Merge Request Hook"))
+	assert.Equal(t, &a.SyncRoutes[1], a.syncRouteOf("ibforuorg", "test2", "Note Hook"))
+	assert.Equal(t, (*syncRoute)(nil), a.syncRouteOf("ibforuorg", "test2", "Merge Request Hook"))
+	assert.Equal(t, (*syncRoute)(nil), a.syncRouteOf("other", "test1", "Note Hook"))
+}
+
+func TestSyncDispatcher(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/slow":
+			time.Sleep(300 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		no    string
+		route syncRoute
+		out   []any
+	}{
+		{
+			"case0",
+			syncRoute{Timeout: duration(100 * time.Millisecond)},
+			[]any{http.StatusGatewayTimeout, []pluginResult{
+				{Plugin: "ok", Required: true, Status: http.StatusOK},
+				{Plugin: "fail", Required: true, Status: http.StatusInternalServerError, Error: "the plugin responded with status 500"},
+				{Plugin: "slow", Required: true, Error: errSyncTimeout.Error()},
+			}},
+		},
+		{
+			"case1",
+			syncRoute{Timeout: duration(100 * time.Millisecond), Required: []string{"ok", "fail"}},
+			[]any{http.StatusBadGateway, []pluginResult{
+				{Plugin: "ok", Required: true, Status: http.StatusOK},
+				{Plugin: "fail", Required: true, Status: http.StatusInternalServerError, Error: "the plugin responded with status 500"},
+				{Plugin: "slow", Required: false, Error: errSyncTimeout.Error()},
+			}},
+		},
+		{
+			"case2",
+			syncRoute{Required: []string{"ok", "slow"}},
+			[]any{http.StatusOK, []pluginResult{
+				{Plugin: "ok", Required: true, Status: http.StatusOK},
+				{Plugin: "fail", Required: false, Status: http.StatusInternalServerError, Error: "the plugin responded with status 500"},
+				{Plugin: "slow", Required: true, Status: http.StatusOK},
+			}},
+		},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			bot := newRobot(&configuration{ConfigItems: accessConfig{
+				RepoPlugins: map[string][]string{"ibforuorg": {"ok", "fail", "slow"}},
+				Plugins: []pluginConfig{
+					{Name: "ok", Endpoint: server.URL + "/ok", Events: []string{eventComment}},
+					{Name: "fail", Endpoint: server.URL + "/fail", Events: []string{eventComment}},
+					{Name: "slow", Endpoint: server.URL + "/slow", Events: []string{eventComment}},
+				},
+				SyncRoutes: []syncRoute{testCases[i].route},
+			}})
+
+			data, _ := os.ReadFile(findTestdata(t, "pr_note.json"))
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/sync", bytes.NewReader(data))
+			req.Header.Set(headerContentTypeName, headerContentTypeJsonValue)
+			req.Header.Set(headerEventType, headerEventTypeValue)
+			req.Header.Set(headerEventGUID, headerEventGUIDValue)
+			bot.ServeHTTP(w, req)
+
+			assert.Equal(t, testCases[i].out[0], w.Result().StatusCode)
+			assert.Equal(t, contentTypeJSON, w.Result().Header.Get(headerContentType))
+
+			var resp struct {
+				DeliveryID string         `json:"deliveryID"`
+				Results    []pluginResult `json:"results"`
+			}
+			assert.Equal(t, nil, json.NewDecoder(w.Result().Body).Decode(&resp))
+			assert.NotEqual(t, "", resp.DeliveryID)
+			assert.Equal(t, w.Result().Header.Get(headerRobotDeliveryID), resp.DeliveryID)
+			assert.Equal(t, testCases[i].out[1], resp.Results)
+
+			bot.wait()
+		})
+	}
+}
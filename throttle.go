@@ -0,0 +1,281 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"errors"
+	"golang.org/x/time/rate"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	throttleReject = "reject"
+	throttleDelay  = "delay"
+
+	scopeSourceIP = "ip"
+	scopeOrg      = "org"
+	scopeRepo     = "repo"
+
+	defaultMaxThrottleDelay = 30 * time.Second
+	throttleIdleTimeout     = 10 * time.Minute
+)
+
+type rateLimitsConfig struct {
+	// Repos maps the orgs (eg "ibforuorg") and the repositories (eg "ibforuorg/test1")
+	// to their rate limits. The limit of an org is shared by all its repositories.
+	Repos map[string]rateLimit `json:"repos,omitempty"`
+
+	// SourceIP is the rate limit of each source IP.
+	SourceIP *rateLimit `json:"source_ip,omitempty"`
+
+	// TrustForwardedFor takes the source IP from the header X-Forwarded-For,
+	// it must be set only if the gateway is behind a trusted proxy.
+	TrustForwardedFor bool `json:"trust_forwarded_for,omitempty"`
+
+	// TrustedHops is the number of the trusted proxies appending to X-Forwarded-For,
+	// the source IP is the entry appended by the outermost of them, counted from the
+	// right, as the entries on its left are set by the client. Defaults to 1.
+	TrustedHops int `json:"trusted_hops,omitempty"`
+
+	// Action on the excess events, "reject" responds 429 and "delay" accepts them but
+	// dispatches them once they are allowed, unless the delay exceeds MaxDelay.
+	// Defaults to "reject".
+	Action string `json:"action,omitempty"`
+
+	// MaxDelay is the longest delay of an event in the "delay" action. Defaults to "30s".
+	MaxDelay duration `json:"max_delay,omitempty"`
+}
+
+// rateLimit is a token bucket, which is refilled by Rate tokens per second up to Burst.
+type rateLimit struct {
+	Rate float64 `json:"rate"`
+
+	// Burst defaults to the rate rounded up.
+	Burst int `json:"burst,omitempty"`
+}
+
+func (l *rateLimit) validate() error {
+	if l.Rate <= 0 {
+		return errors.New("the rate must be positive")
+	}
+	if l.Burst < 0 {
+		return errors.New("the burst can not be negative")
+	}
+
+	return nil
+}
+
+func (l *rateLimit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return int(math.Ceil(l.Rate))
+}
+
+func (c *rateLimitsConfig) validate() error {
+	for k, v := range c.Repos {
+		if err := v.validate(); err != nil {
+			return errors.New("rate limit of " + k + ": " + err.Error())
+		}
+	}
+
+	if c.SourceIP != nil {
+		if err := c.SourceIP.validate(); err != nil {
+			return errors.New("rate limit of source ip: " + err.Error())
+		}
+	}
+
+	if c.TrustedHops < 0 {
+		return errors.New("the trusted hops of the rate limits can not be negative")
+	}
+
+	if c.Action != "" && c.Action != throttleReject && c.Action != throttleDelay {
+		return errors.New("rate limits have an unknown action " + c.Action)
+	}
+
+	return nil
+}
+
+// maxDelay returns how long an event is allowed to wait for the tokens.
+func (c *rateLimitsConfig) maxDelay() time.Duration {
+	if c.Action != throttleDelay {
+		return 0
+	}
+	return c.MaxDelay.or(defaultMaxThrottleDelay)
+}
+
+// sourceIP returns the IP of the client sending the webhook.
+func (c *rateLimitsConfig) sourceIP(r *http.Request) string {
+	if c.TrustForwardedFor {
+		var hops []string
+		for _, v := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+		// the leftmost entry is taken if there are fewer hops than the trusted ones
+		if i := len(hops) - max(c.TrustedHops, 1); len(hops) > 0 {
+			return strings.TrimSpace(hops[max(i, 0)])
+		}
+	}
+
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return ip
+	}
+	return r.RemoteAddr
+}
+
+// throttleKey identifies a token bucket.
+type throttleKey struct {
+	scope string
+	key   string
+	limit *rateLimit
+}
+
+// repoKeys returns the buckets of the org and the repository which are configured.
+func (c *rateLimitsConfig) repoKeys(org, repo string) []throttleKey {
+	var keys []throttleKey
+	if v, ok := c.Repos[org]; ok {
+		keys = append(keys, throttleKey{scope: scopeOrg, key: org, limit: &v})
+	}
+	if v, ok := c.Repos[org+"/"+repo]; ok {
+		keys = append(keys, throttleKey{scope: scopeRepo, key: org + "/" + repo, limit: &v})
+	}
+
+	return keys
+}
+
+type throttleEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// throttle keeps the token buckets of the inbound rate limiting.
+type throttle struct {
+	lock    sync.Mutex
+	entries map[string]*throttleEntry
+	swept   time.Time
+}
+
+func newThrottle() *throttle {
+	return &throttle{entries: map[string]*throttleEntry{}}
+}
+
+// reserve takes a token from each bucket, and returns how long the event must wait,
+// the key of the longest wait and the func giving the tokens back. If the wait exceeds
+// maxDelay, the tokens are given back and the event is rejected.
+func (t *throttle) reserve(now time.Time, maxDelay time.Duration, keys []throttleKey) (
+	delay time.Duration, slowest *throttleKey, cancel func(), ok bool,
+) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.sweep(now)
+
+	reservations := make([]*rate.Reservation, 0, len(keys))
+	for i := range keys {
+		r := t.limiterOf(now, &keys[i]).ReserveN(now, 1)
+		if d := r.DelayFrom(now); d > delay || !r.OK() {
+			delay, slowest = d, &keys[i]
+		}
+		reservations = append(reservations, r)
+	}
+
+	// the tokens are given back as of the reservation, a reservation not waiting
+	// for its tokens has already acted at any later time
+	cancelAt := func() {
+		for _, r := range reservations {
+			r.CancelAt(now)
+		}
+	}
+	cancel = func() {
+		t.lock.Lock()
+		defer t.lock.Unlock()
+
+		cancelAt()
+	}
+	if delay > maxDelay {
+		cancelAt()
+		return delay, slowest, cancel, false
+	}
+
+	return delay, slowest, cancel, true
+}
+
+// limiterOf returns the limiter of the key, which follows the changes of the configmap.
+func (t *throttle) limiterOf(now time.Time, k *throttleKey) *rate.Limiter {
+	id := k.scope + ":" + k.key
+	e, ok := t.entries[id]
+	if !ok {
+		e = &throttleEntry{limiter: rate.NewLimiter(rate.Limit(k.limit.Rate), k.limit.burst())}
+		t.entries[id] = e
+	}
+	e.lastSeen = now
+
+	if e.limiter.Limit() != rate.Limit(k.limit.Rate) {
+		e.limiter.SetLimitAt(now, rate.Limit(k.limit.Rate))
+	}
+	if e.limiter.Burst() != k.limit.burst() {
+		e.limiter.SetBurstAt(now, k.limit.burst())
+	}
+
+	return e.limiter
+}
+
+// sweep removes the idle buckets, so the ones of the source IPs do not pile up.
+// The lock must be held.
+func (t *throttle) sweep(now time.Time) {
+	if now.Sub(t.swept) < time.Minute {
+		return
+	}
+	t.swept = now
+
+	for id, e := range t.entries {
+		if now.Sub(e.lastSeen) > throttleIdleTimeout {
+			delete(t.entries, id)
+		}
+	}
+}
+
+// throttled applies the rate limits of the keys to the webhook. It responds 429 and
+// returns false if the webhook is rejected, otherwise it returns the delay of it and
+// the func giving the tokens back if it is rejected later.
+func (bot *robot) throttled(w http.ResponseWriter, keys []throttleKey) (time.Duration, func(), bool) {
+	if len(keys) == 0 {
+		return 0, func() {}, true
+	}
+
+	cfg := &bot.configmap.ConfigItems.RateLimits
+	delay, slowest, cancel, ok := bot.throttle.reserve(time.Now(), cfg.maxDelay(), keys)
+	if !ok {
+		throttledRequests.WithLabelValues(slowest.scope, throttleReject).Inc()
+		bot.requestLog(w).WithField("scope", slowest.scope).WithField("key", slowest.key).Warning(tooManyRequestsErrorMessage)
+
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(min(delay, time.Hour).Seconds()))))
+		reject(w, http.StatusTooManyRequests, tooManyRequestsErrorMessage)
+		return 0, cancel, false
+	}
+
+	if delay > 0 {
+		throttledRequests.WithLabelValues(slowest.scope, throttleDelay).Inc()
+		bot.requestLog(w).WithField("scope", slowest.scope).WithField("key", slowest.key).WithField("delay", delay.String()).
+			Info("the request is delayed by the rate limits")
+	}
+
+	return delay, cancel, true
+}
@@ -0,0 +1,145 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestThrottleReserve(t *testing.T) {
+	now := time.Now()
+	org := throttleKey{scope: scopeOrg, key: "ibforuorg", limit: &rateLimit{Rate: 1, Burst: 2}}
+	repo := throttleKey{scope: scopeRepo, key: "ibforuorg/test1", limit: &rateLimit{Rate: 10}}
+	keys := []throttleKey{org, repo}
+
+	th := newThrottle()
+	for i := 0; i < 2; i++ {
+		delay, _, _, ok := th.reserve(now, 0, keys)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, time.Duration(0), delay)
+	}
+
+	// the org bucket is empty
+	delay, slowest, _, ok := th.reserve(now, 0, keys)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, time.Second, delay)
+	assert.Equal(t, scopeOrg, slowest.scope)
+
+	// the tokens of the rejected event are given back
+	delay, slowest, _, ok = th.reserve(now, 2*time.Second, keys)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, time.Second, delay)
+	assert.Equal(t, scopeOrg, slowest.scope)
+
+	delay, _, _, ok = th.reserve(now, 2*time.Second, keys)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, 2*time.Second, delay)
+
+	// the repo bucket is not affected by the rejected events
+	_, _, _, ok = th.reserve(now, 0, keys[1:])
+	assert.Equal(t, true, ok)
+	assert.Equal(t, 2, len(th.entries))
+
+	th.reserve(now.Add(throttleIdleTimeout+time.Minute), 0, nil)
+	assert.Equal(t, 0, len(th.entries))
+}
+
+func TestSourceIP(t *testing.T) {
+	testCases := []struct {
+		no     string
+		config rateLimitsConfig
+		xff    []string
+		out    string
+	}{
+		{"case0", rateLimitsConfig{}, []string{"1.1.1.1"}, "10.0.0.1"},
+		{"case1", rateLimitsConfig{TrustForwardedFor: true}, nil, "10.0.0.1"},
+		{"case2", rateLimitsConfig{TrustForwardedFor: true}, []string{"6.6.6.6, 1.1.1.1"}, "1.1.1.1"},
+		{"case3", rateLimitsConfig{TrustForwardedFor: true, TrustedHops: 2}, []string{"6.6.6.6, 1.1.1.1", "2.2.2.2"}, "1.1.1.1"},
+		{"case4", rateLimitsConfig{TrustForwardedFor: true, TrustedHops: 3}, []string{"1.1.1.1, 2.2.2.2"}, "1.1.1.1"},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/ip", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			for _, v := range testCases[i].xff {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			assert.Equal(t, testCases[i].out, testCases[i].config.sourceIP(req))
+		})
+	}
+}
+
+func TestServeHTTPRateLimits(t *testing.T) {
+	data, _ := os.ReadFile(findTestdata(t, "pr_note.json"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	testCases := []struct {
+		no      string
+		limits  rateLimitsConfig
+		out     []int
+		delayed float64
+		byIP    float64
+	}{
+		{"case0", rateLimitsConfig{SourceIP: &rateLimit{Rate: 0.001}}, []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests}, 0, 2},
+		{"case1", rateLimitsConfig{Repos: map[string]rateLimit{"ibforuorg/test1": {Rate: 0.001, Burst: 2}}}, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, 0, 0},
+		{"case2", rateLimitsConfig{Repos: map[string]rateLimit{"ibforuorg": {Rate: 20, Burst: 1}}, Action: throttleDelay}, []int{http.StatusOK, http.StatusOK, http.StatusOK}, 2, 0},
+		{"case3", rateLimitsConfig{Repos: map[string]rateLimit{"other": {Rate: 0.001}}}, []int{http.StatusOK, http.StatusOK, http.StatusOK}, 0, 0},
+		// the source ip tokens of the webhooks rejected by the repo are given back
+		{"case4", rateLimitsConfig{SourceIP: &rateLimit{Rate: 0.001, Burst: 2}, Repos: map[string]rateLimit{"ibforuorg/test1": {Rate: 0.001}}}, []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests}, 0, 0},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			throttledRequests.Reset()
+			bot := newRobot(&configuration{ConfigItems: accessConfig{
+				RepoPlugins: map[string][]string{"ibforuorg": {"serv1"}},
+				Plugins:     []pluginConfig{{Name: "serv1", Endpoint: server.URL, Events: []string{eventComment}}},
+				RateLimits:  testCases[i].limits,
+			}})
+
+			rejected := 0
+			for _, code := range testCases[i].out {
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest(http.MethodPost, "http://localhost:8080/limits", bytes.NewReader(data))
+				req.RemoteAddr = "192.0.2.1:12345"
+				req.Header.Set(headerContentTypeName, headerContentTypeJsonValue)
+				req.Header.Set(headerEventType, headerEventTypeValue)
+				req.Header.Set(headerEventGUID, headerEventGUIDValue)
+				bot.ServeHTTP(w, req)
+
+				assert.Equal(t, code, w.Result().StatusCode)
+				if code == http.StatusTooManyRequests {
+					rejected++
+					assert.NotEqual(t, "", w.Result().Header.Get("Retry-After"))
+				}
+			}
+			bot.wait()
+
+			total := 0.0
+			for _, scope := range []string{scopeSourceIP, scopeOrg, scopeRepo} {
+				total += testutil.ToFloat64(throttledRequests.WithLabelValues(scope, throttleReject))
+			}
+			assert.Equal(t, float64(rejected), total)
+			assert.Equal(t, testCases[i].byIP, testutil.ToFloat64(throttledRequests.WithLabelValues(scopeSourceIP, throttleReject)))
+			assert.Equal(t, testCases[i].delayed, testutil.ToFloat64(throttledRequests.WithLabelValues(scopeOrg, throttleDelay)))
+		})
+	}
+}
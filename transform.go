@@ -0,0 +1,231 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// transformConfig transforms the payload into the body delivered to the plugin,
+// either by a Go text/template or by a projection of JSONPath.
+type transformConfig struct {
+	// Template renders the body from the payload, eg `{"number": {{json .number}}}`.
+	// The function "json" encodes a value in JSON.
+	Template string `json:"template,omitempty"`
+
+	// Fields projects the payload into a JSON object, it maps the keys of the object
+	// to the JSONPath of the values, eg {"number": "$.number", "author": "$.author"}.
+	Fields map[string]string `json:"fields,omitempty"`
+
+	// Headers are the extra headers of the body, the values are templates as well.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// ContentType of the body rendered by Template or Fields. Defaults to "application/json".
+	ContentType string `json:"content_type,omitempty"`
+
+	once    sync.Once
+	err     error
+	tmpl    *template.Template
+	headers map[string]*template.Template
+	fields  map[string]jsonPath
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// compiled parses the transformation once, the errors tell where it is wrong.
+func (t *transformConfig) compiled() error {
+	t.once.Do(func() {
+		t.err = t.compile()
+	})
+
+	return t.err
+}
+
+func (t *transformConfig) compile() error {
+	if t.Template != "" && len(t.Fields) > 0 {
+		return errors.New("only one of template and fields can be set")
+	}
+	if t.Template == "" && len(t.Fields) == 0 && len(t.Headers) == 0 {
+		return errors.New("one of template, fields and headers must be set")
+	}
+
+	if t.Template != "" {
+		tmpl, err := template.New("template").Funcs(templateFuncs).Parse(t.Template)
+		if err != nil {
+			return err
+		}
+		t.tmpl = tmpl
+	}
+
+	t.headers = make(map[string]*template.Template, len(t.Headers))
+	for _, k := range sortedKeys(t.Headers) {
+		tmpl, err := template.New("headers." + k).Funcs(templateFuncs).Parse(t.Headers[k])
+		if err != nil {
+			return err
+		}
+		t.headers[k] = tmpl
+	}
+
+	t.fields = make(map[string]jsonPath, len(t.Fields))
+	for _, k := range sortedKeys(t.Fields) {
+		path, err := parseJSONPath(t.Fields[k])
+		if err != nil {
+			return fmt.Errorf("fields.%s: %w", k, err)
+		}
+		t.fields[k] = path
+	}
+
+	return nil
+}
+
+// apply evaluates the transformation against the body of the message, which must be JSON.
+// The content type of the new body is returned, it is empty if only the headers are set
+// and the body is left as is.
+func (t *transformConfig) apply(msg *message) (contentType string, err error) {
+	if err = t.compiled(); err != nil {
+		return
+	}
+
+	// the numbers are kept as they are, eg the ids beyond the precision of float64
+	var payload any
+	dec := json.NewDecoder(bytes.NewReader(msg.body))
+	dec.UseNumber()
+	if err = dec.Decode(&payload); err != nil {
+		return "", fmt.Errorf("the payload can not be transformed: %w", err)
+	}
+
+	for k, tmpl := range t.headers {
+		buf := bytes.Buffer{}
+		if err = tmpl.Execute(&buf, payload); err != nil {
+			return
+		}
+		msg.header.Set(k, buf.String())
+	}
+
+	switch {
+	case t.tmpl != nil:
+		buf := bytes.Buffer{}
+		if err = t.tmpl.Execute(&buf, payload); err != nil {
+			return
+		}
+		msg.body = buf.Bytes()
+	case len(t.fields) > 0:
+		obj := make(map[string]any, len(t.fields))
+		for k, path := range t.fields {
+			obj[k] = path.eval(payload)
+		}
+		if msg.body, err = json.Marshal(obj); err != nil {
+			return
+		}
+	default:
+		return
+	}
+
+	contentType = t.ContentType
+	if contentType == "" {
+		contentType = contentTypeJSON
+	}
+
+	return
+}
+
+// jsonPath is a subset of JSONPath, which supports the child operators only,
+// eg "$.author", "$.labels[0].name" or "$['html-url']".
+type jsonPath []any
+
+func parseJSONPath(s string) (jsonPath, error) {
+	if !strings.HasPrefix(s, "$") {
+		return nil, fmt.Errorf("%q must start with $", s)
+	}
+
+	var path jsonPath
+	for i := 1; i < len(s); {
+		switch s[i] {
+		case '.':
+			j := i + 1
+			for j < len(s) && s[j] != '.' && s[j] != '[' {
+				j++
+			}
+			if j == i+1 {
+				return nil, fmt.Errorf("%q has an empty key at %d", s, i+1)
+			}
+			path = append(path, s[i+1:j])
+			i = j
+		case '[':
+			j := strings.IndexByte(s[i:], ']')
+			if j < 0 {
+				return nil, fmt.Errorf("%q has an unclosed [ at %d", s, i)
+			}
+			item := s[i+1 : i+j]
+			if n, err := strconv.Atoi(item); err == nil && n >= 0 {
+				path = append(path, n)
+			} else if len(item) >= 2 && item[0] == '\'' && item[len(item)-1] == '\'' {
+				path = append(path, item[1:len(item)-1])
+			} else {
+				return nil, fmt.Errorf("%q has an invalid index %s at %d", s, item, i+1)
+			}
+			i += j + 1
+		default:
+			return nil, fmt.Errorf("%q has an unexpected %q at %d", s, s[i], i)
+		}
+	}
+
+	return path, nil
+}
+
+// eval returns the value at the path, nil if it does not exist.
+func (p jsonPath) eval(v any) any {
+	for _, item := range p {
+		switch key := item.(type) {
+		case string:
+			obj, ok := v.(map[string]any)
+			if !ok {
+				return nil
+			}
+			v = obj[key]
+		case int:
+			arr, ok := v.([]any)
+			if !ok || key >= len(arr) {
+				return nil
+			}
+			v = arr[key]
+		}
+	}
+
+	return v
+}
+
+// sortedKeys returns the keys of the map in order, so the errors are stable.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,120 @@
+// Copyright (c) Huawei Technologies Co., Ltd. 2024. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	testCases := []struct {
+		no  string
+		in  string
+		out []any
+	}{
+		{"case0", "$", []any{jsonPath(nil), nil}},
+		{"case1", "$.author", []any{jsonPath{"author"}, nil}},
+		{"case2", "$.labels[0].name", []any{jsonPath{"labels", 0, "name"}, nil}},
+		{"case3", "$['html-url']", []any{jsonPath{"html-url"}, nil}},
+		{"case4", "author", []any{jsonPath(nil), errors.New(`"author" must start with $`)}},
+		{"case5", "$..author", []any{jsonPath(nil), errors.New(`"$..author" has an empty key at 2`)}},
+		{"case6", "$.labels[x]", []any{jsonPath(nil), errors.New(`"$.labels[x]" has an invalid index x at 9`)}},
+		{"case7", "$author", []any{jsonPath(nil), errors.New(`"$author" has an unexpected 'a' at 1`)}},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			path, err := parseJSONPath(testCases[i].in)
+			assert.Equal(t, testCases[i].out[0], path)
+			assert.Equal(t, testCases[i].out[1], err)
+		})
+	}
+}
+
+func TestEncodeTransform(t *testing.T) {
+	d := newTestDelivery()
+	number, author := "4", "dummy"
+	d.event.Number = &number
+	d.event.Author = &author
+
+	msg, err := d.encode(&pluginConfig{Name: "serv1", Transform: &transformConfig{
+		Fields:  map[string]string{"number": "$.number", "author": "$.author", "missing": "$.labels[0]"},
+		Headers: map[string]string{"X-Robot-Number": "{{.number}}"},
+	}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, `{"author":"dummy","missing":null,"number":"4"}`, string(msg.body))
+	assert.Equal(t, "4", msg.header.Get("X-Robot-Number"))
+	assert.Equal(t, contentTypeJSON, msg.header.Get(headerContentType))
+	assert.Equal(t, "", msg.header.Get(headerRobotChain))
+
+	msg, err = d.encode(&pluginConfig{Name: "serv1", Payload: payloadRaw, Transform: &transformConfig{
+		Template:    `kind={{.object_kind}} {{json .unknown}}`,
+		ContentType: "text/plain",
+	}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, `kind=note 1`, string(msg.body))
+	assert.Equal(t, "text/plain", msg.header.Get(headerContentType))
+
+	// the large numbers are kept as they are
+	raw := newTestDelivery()
+	raw.raw = newMemorySpool([]byte(`{"id":12345678901234567890,"ratio":0.1}`))
+	msg, err = raw.encode(&pluginConfig{Name: "serv1", Payload: payloadRaw, Transform: &transformConfig{
+		Fields:  map[string]string{"id": "$.id", "ratio": "$.ratio"},
+		Headers: map[string]string{"X-Robot-Id": "{{.id}}"},
+	}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, `{"id":12345678901234567890,"ratio":0.1}`, string(msg.body))
+	assert.Equal(t, "12345678901234567890", msg.header.Get("X-Robot-Id"))
+
+	// the body is left as is if only the headers are set
+	generic, _ := d.encode(&pluginConfig{Name: "serv1"})
+	msg, err = d.encode(&pluginConfig{Name: "serv1", Transform: &transformConfig{
+		Headers:     map[string]string{"X-Robot-Number": "{{.number}}"},
+		ContentType: "text/plain",
+	}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, generic.body, msg.body)
+	assert.Equal(t, "4", msg.header.Get("X-Robot-Number"))
+	assert.Equal(t, generic.header.Get(headerContentType), msg.header.Get(headerContentType))
+	assert.Equal(t, headerRobotChainAuthed, msg.header.Get(headerRobotChain))
+
+	_, err = d.encode(&pluginConfig{Name: "serv1", Transform: &transformConfig{
+		Template: `{{.number}}`,
+		Fields:   map[string]string{"number": "$.number"},
+	}})
+	assert.Equal(t, errors.New("only one of template and fields can be set"), err)
+}
+
+func TestValidateTransformStructured(t *testing.T) {
+	testCases := []struct {
+		no  string
+		in  string
+		out error
+	}{
+		{"case0", "", nil},
+		{"case1", contentTypeJSON, nil},
+		{"case2", "application/vnd.api+json", nil},
+		{"case3", "text/plain", errors.New("serv1 plugin can not transform into text/plain in the cloudevents-structured format")},
+	}
+	for i := range testCases {
+		t.Run(testCases[i].no, func(t *testing.T) {
+			p := &pluginConfig{
+				Name: "serv1", Endpoint: "http://localhost:7001/gitcode-hook", Format: formatCloudEventsStructured,
+				Transform: &transformConfig{Template: `{{json .number}}`, ContentType: testCases[i].in},
+			}
+			assert.Equal(t, testCases[i].out, p.validate())
+		})
+	}
+}